@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/maxott/magda-cli/pkg/apply"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	cliApply(App())
+}
+
+/**** APPLY ****/
+
+func cliApply(app *kingpin.Application) {
+	var paths []string
+	var yes, force, noValidate, noColor bool
+	c := app.Command("apply", "Bring aspect definitions and records in line with manifest files").Action(func(_ *kingpin.ParseContext) error {
+		m, err := apply.LoadManifests(paths...)
+		if err != nil {
+			App().Fatalf("%s", err)
+		}
+		ctxt := context.Background()
+		plan, err := apply.MakePlan(ctxt, m, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		printPlan(plan, !noColor && isTerminal(os.Stdout))
+		if plan.Count(apply.NoChange) == len(plan.Steps) {
+			return nil
+		}
+		if !noValidate {
+			if err := plan.Validate(ctxt, Validator()); err != nil {
+				return err
+			}
+		}
+		breaking := plan.Breaking()
+		if !yes {
+			if breaking > 0 && !force {
+				fmt.Println("\nRun again with --yes --force to apply these changes, including the breaking ones")
+			} else {
+				fmt.Println("\nRun again with --yes to apply these changes")
+			}
+			return nil
+		}
+		if breaking > 0 && !force {
+			return fmt.Errorf("refusing to apply %d breaking schema change(s), use --force to apply anyway", breaking)
+		}
+		return plan.Apply(ctxt, Adapter(), Logger(), func(s *apply.Step) {
+			if s.Kind == apply.AspectKind {
+				Validator().Forget(s.ID)
+			}
+			fmt.Fprintf(os.Stderr, "Successfully %sd %s '%s'\n", s.Op, s.Kind, s.ID)
+		})
+	})
+	c.Flag("file", "Manifest file, or directory of '.yaml' manifests").
+		Short('f').
+		Required().
+		StringsVar(&paths)
+	c.Flag("yes", "Execute the plan").
+		BoolVar(&yes)
+	c.Flag("force", "Execute the plan even if existing data may no longer match a changed schema").
+		BoolVar(&force)
+	c.Flag("no-validate", "Don't check the aspects of records against their schemas").
+		BoolVar(&noValidate)
+	c.Flag("no-color", "Don't color the plan").
+		BoolVar(&noColor)
+}
+
+func printPlan(plan *apply.Plan, useColor bool) {
+	for _, s := range plan.Steps {
+		switch s.Op {
+		case apply.Create:
+			fmt.Printf("+ create %s '%s'\n", s.Kind, s.ID)
+		case apply.Update:
+			fmt.Printf("~ update %s '%s'\n", s.Kind, s.ID)
+			printChanges(s.Changes, "    ", useColor)
+			printSchemaChanges(s.Breaking(), "    ", useColor, os.Stdout)
+		default:
+			fmt.Printf("  %s '%s' is up to date\n", s.Kind, s.ID)
+		}
+	}
+	fmt.Printf("\nPlan: %d to create, %d to update, %d unchanged\n",
+		plan.Count(apply.Create), plan.Count(apply.Update), plan.Count(apply.NoChange))
+	if n := plan.Breaking(); n > 0 {
+		fmt.Printf("%d breaking schema change(s)\n", n)
+	}
+}
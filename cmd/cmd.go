@@ -2,9 +2,17 @@
 package cmd
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
 	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/schema"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"os"
 
 	log "go.uber.org/zap"
 )
@@ -13,6 +21,7 @@ var (
 	app = kingpin.New("magda-cli", "Managing records & schemas in Magda.")
 
 	host        = app.Flag("host", "DNS name/IP of Magda host [MAGDA_HOST]").Short('H').Envar("MAGDA_HOST").String()
+	baseURL     = app.Flag("base-url", "Base URL of Magda, e.g. 'https://corp.example/magda', instead of host & use-tls [MAGDA_URL]").Envar("MAGDA_URL").String()
 	tenantID    = app.Flag("tenant-id", "Tenant ID [MAGDA_TENANT_ID]").Envar("MAGDA_TENANT_ID").String()
 	authID      = app.Flag("auth-id", "Authorization Key ID [MAGDA_AUTH_ID]").Envar("MAGDA_AUTH_ID").String()
 	authKey     = app.Flag("auth-key", "Authorization Key [MAGDA_AUTH_KEY]").Envar("MAGDA_AUTH_KEY").String()
@@ -22,9 +31,46 @@ var (
 	jwtSecret = app.Flag("jwt-secret", "Secret used for creating JWT token for inernal comms [MAGDA_JWT_SECRET]").Envar("MAGDA_JWT_SECRET").String()
 	jwtUser   = app.Flag("jwt-user-id", "User ID for creating JWT token for inernal comms [MAGDA_JWT_USER_ID]").Envar("MAGDA_JWT_USER_ID").String()
 
+	registryPath = app.Flag("registry-path", "Base path of registry API [MAGDA_REGISTRY_PATH]").Envar("MAGDA_REGISTRY_PATH").PlaceHolder("/api/v0/registry").String()
+	searchPath   = app.Flag("search-path", "Base path of search API [MAGDA_SEARCH_PATH]").Envar("MAGDA_SEARCH_PATH").PlaceHolder("/api/v0/search").String()
+	hooksPath    = app.Flag("hooks-path", "Base path of hooks API, defaults to registry path + '/hooks' [MAGDA_HOOKS_PATH]").Envar("MAGDA_HOOKS_PATH").String()
+
+	retryMax = app.Flag("retry-max", "Maximum number of attempts for each registry call [MAGDA_RETRY_MAX]").
+			Default("3").Envar("MAGDA_RETRY_MAX").Int()
+	retryBaseDelay = app.Flag("retry-base-delay", "Delay before first retry, doubled for every further one [MAGDA_RETRY_BASE_DELAY]").
+			Default("500ms").Envar("MAGDA_RETRY_BASE_DELAY").Duration()
+	retryMaxDelay = app.Flag("retry-max-delay", "Maximum delay between retries [MAGDA_RETRY_MAX_DELAY]").
+			Default("30s").Envar("MAGDA_RETRY_MAX_DELAY").Duration()
+	retryJitter = app.Flag("retry-jitter", "Fraction (0..1) of retry delay to randomise [MAGDA_RETRY_JITTER]").
+			Default("0.2").Envar("MAGDA_RETRY_JITTER").Float64()
+	retryNonIdempotent = app.Flag("retry-non-idempotent", "Also retry POST & PATCH requests [MAGDA_RETRY_NON_IDEMPOTENT]").
+				Default("false").Envar("MAGDA_RETRY_NON_IDEMPOTENT").Bool()
+
+	requestTimeout = app.Flag("request-timeout", "Time limit for a single registry call [MAGDA_REQUEST_TIMEOUT]").
+			Default(adapter.DefaultTimeout.String()).Envar("MAGDA_REQUEST_TIMEOUT").Duration()
+	dialTimeout = app.Flag("dial-timeout", "Time limit for connecting to Magda host [MAGDA_DIAL_TIMEOUT]").
+			Default(adapter.DefaultDialTimeout.String()).Envar("MAGDA_DIAL_TIMEOUT").Duration()
+	proxyURL           = app.Flag("proxy", "URL of HTTP proxy (defaults to HTTPS_PROXY/HTTP_PROXY) [MAGDA_PROXY]").Envar("MAGDA_PROXY").String()
+	caFile             = app.Flag("ca-file", "PEM file with additional root CAs [MAGDA_CA_FILE]").Envar("MAGDA_CA_FILE").String()
+	clientCertFile     = app.Flag("client-cert", "PEM file with client certificate for mTLS [MAGDA_CLIENT_CERT]").Envar("MAGDA_CLIENT_CERT").String()
+	clientKeyFile      = app.Flag("client-key", "PEM file with client key for mTLS [MAGDA_CLIENT_KEY]").Envar("MAGDA_CLIENT_KEY").String()
+	insecureSkipVerify = app.Flag("insecure-skip-verify", "Don't verify server certificate, dev clusters only! [MAGDA_INSECURE_SKIP_VERIFY]").
+				Default("false").Envar("MAGDA_INSECURE_SKIP_VERIFY").Bool()
+
+	headers = app.Flag("header", "Additional HTTP header for every call to Magda (repeatable)").PlaceHolder("NAME=VALUE").StringMap()
+
+	recordSession = app.Flag("record-session", "Record all calls to Magda into FILE (.json or .yaml)").PlaceHolder("FILE").String()
+	replaySession = app.Flag("replay-session", "Replay calls to Magda from FILE instead of contacting Magda").PlaceHolder("FILE").ExistingFile()
+
+	schemaCacheTTL = app.Flag("schema-cache-ttl", "How long aspect schemas fetched for validation are cached, 0 to disable [MAGDA_SCHEMA_CACHE_TTL]").
+			Envar("MAGDA_SCHEMA_CACHE_TTL").Default(schema.DefaultCacheTTL.String()).Duration()
+
 	useYaml = app.Flag("use-yaml", "Use and assume data formated in YAML [MAGDA_USE_YAML]").Short('y').Envar("MAGDA_USE_YAML").Bool()
 
-	logger *log.Logger
+	logger    *log.Logger
+	adpt      *adapter.Adapter  // created on first use
+	cassette  *adapter.Cassette // set when recording the session
+	validator *schema.Validator
 )
 
 func App() *kingpin.Application {
@@ -32,12 +78,93 @@ func App() *kingpin.Application {
 }
 
 func Adapter() *adapter.Adapter {
+	if adpt != nil {
+		return adpt
+	}
+	applyProfile()
 	jwtToken := createJwtToken(Logger())
-	adapter := adapter.RestAdapter(adapter.ConnectionCtxt{
-		Host: *host, TenantID: *tenantID, AuthID: *authID, AuthKey: *authKey, UseTLS: *useTLS,
+	connCtxt := adapter.ConnectionCtxt{
+		Host: *host, URL: *baseURL, TenantID: *tenantID, AuthID: *authID, AuthKey: *authKey, UseTLS: *useTLS,
 		SkipGateway: *skipGateway, JwtToken: jwtToken,
-	})
-	return &adapter
+		BasePaths: adapter.BasePaths{Registry: *registryPath, Search: *searchPath, Hooks: *hooksPath},
+		Retry: adapter.RetryPolicy{
+			MaxAttempts: *retryMax, BaseDelay: *retryBaseDelay, MaxDelay: *retryMaxDelay,
+			Jitter: *retryJitter, RetryNonIdempotent: *retryNonIdempotent,
+		},
+		Transport: adapter.TransportCtxt{
+			Timeout: *requestTimeout, DialTimeout: *dialTimeout, ProxyURL: *proxyURL,
+			CAFile: *caFile, ClientCertFile: *clientCertFile, ClientKeyFile: *clientKeyFile,
+			InsecureSkipVerify: *insecureSkipVerify,
+		},
+	}
+	a, err := createAdapter(connCtxt)
+	if err != nil {
+		App().Fatalf("failed to set up connection - %s", err)
+	}
+	adpt = &a
+	return adpt
+}
+
+func createAdapter(connCtxt adapter.ConnectionCtxt) (adapter.Adapter, error) {
+	if *recordSession != "" && *replaySession != "" {
+		App().Fatalf("flags --record-session and --replay-session can't be used together")
+	}
+	middlewares := []adapter.Middleware{adapter.Logging()}
+	if *replaySession != "" {
+		cassette, err := adapter.LoadCassette(*replaySession)
+		if err != nil {
+			return nil, err
+		}
+		return adapter.Chain(adapter.ReplayAdapter(connCtxt, cassette), middlewares...), nil
+	}
+	opts := []adapter.Option{adapter.WithMiddleware(middlewares...)}
+	for name, value := range *headers {
+		opts = append(opts, adapter.WithHeader(name, value))
+	}
+	if tracing := tracingOption(); tracing != nil {
+		opts = append(opts, tracing)
+	}
+	if *recordSession != "" {
+		cassette = adapter.NewCassette(*recordSession)
+		return adapter.NewRecordingAdapter(connCtxt, cassette, opts...)
+	}
+	return adapter.NewRestAdapter(connCtxt, opts...)
+}
+
+// Shutdown releases any resources held on behalf of the commands, and saves
+// the recorded session
+func Shutdown() {
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			Logger().Warn("Shutting down tracer", log.Error(err))
+		}
+	}
+	if cassette != nil {
+		if err := cassette.Close(); err != nil {
+			Logger().Error("Saving recorded session", log.String("file", *recordSession), log.Error(err))
+		}
+	}
+}
+
+// Validator checks aspects against the schemas in Magda, which are cached
+// separately for every registry and tenant
+func Validator() *schema.Validator {
+	if validator != nil {
+		return validator
+	}
+	a := Adapter() // applies the profile first
+	var cache *schema.Cache
+	if *schemaCacheTTL > 0 {
+		if dir, err := schema.DefaultCacheDir(); err != nil {
+			Logger().Warn("Can't cache schemas", log.Error(err))
+		} else {
+			key := strings.Join([]string{*host, *baseURL, strconv.FormatBool(*useTLS), *registryPath, *tenantID}, "|")
+			sum := sha256.Sum256([]byte(key))
+			cache = &schema.Cache{Dir: filepath.Join(dir, hex.EncodeToString(sum[:8])), TTL: *schemaCacheTTL}
+		}
+	}
+	validator = schema.NewValidator(cache, a, Logger())
+	return validator
 }
 
 func Logger() *log.Logger {
@@ -51,11 +178,11 @@ func SetLogger(l *log.Logger) {
 func createJwtToken(logger *log.Logger) string {
 	if *skipGateway {
 		if jwtSecret == nil || jwtUser == nil {
-			logger.Fatal("When skipping gateway, 'jwt-secret' and 'jwt-user-id' are also required")
+			App().Fatalf("when skipping gateway, 'jwt-secret' and 'jwt-user-id' are also required")
 		}
 		token, err := adapter.CreateJwtToken(jwtUser, jwtSecret)
 		if err != nil {
-			logger.Fatal("While signing JWT token", log.Error(err))
+			App().Fatalf("failed to sign JWT token - %s", err)
 		}
 		logger.Debug("JWT Token", log.String("token", token))
 		return token
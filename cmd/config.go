@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/maxott/magda-cli/pkg/config"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	configFile  = app.Flag("config", "Config file with connection profiles [MAGDA_CONFIG]").Envar("MAGDA_CONFIG").PlaceHolder("FILE").String()
+	profileName = app.Flag("profile", "Connection profile to use instead of the current one [MAGDA_PROFILE]").Envar("MAGDA_PROFILE").String()
+
+	cfg      *config.Config      // loaded on first use
+	cliFlags = map[string]bool{} // flags set on the command line
+)
+
+func init() {
+	app.PreAction(func(c *kingpin.ParseContext) error {
+		for _, el := range c.Elements {
+			if f, ok := el.Clause.(*kingpin.FlagClause); ok {
+				cliFlags[f.Model().Name] = true
+			}
+		}
+		return nil
+	})
+
+	cmd := App().Command("config", "Managing connection profiles")
+	cliConfigList(cmd)
+	cliConfigUse(cmd)
+	cliConfigShow(cmd)
+	cliConfigSet(cmd)
+}
+
+func Config() *config.Config {
+	if cfg != nil {
+		return cfg
+	}
+	fileName := *configFile
+	if fileName == "" {
+		var err error
+		if fileName, err = config.DefaultFileName(); err != nil {
+			App().Fatalf("failed to locate config file - %s", err)
+		}
+	}
+	c, err := config.Load(fileName)
+	if err != nil {
+		App().Fatalf("failed to load config - %s", err)
+	}
+	cfg = c
+	return cfg
+}
+
+// applyProfile sets all connection flags from the active profile, unless they
+// have already been set through the command line or their environment variable.
+func applyProfile() {
+	p, err := Config().Profile(*profileName)
+	if err != nil {
+		App().Fatalf("%s in '%s'", err, Config().FileName())
+	}
+	if p == nil {
+		return
+	}
+	setString := func(key string, envar string, v *string) {
+		if s := p.Get(key); s != "" && !isSetByUser(key, envar) {
+			*v = s
+		}
+	}
+	setBool := func(key string, envar string, v *bool, b bool) {
+		if b && !isSetByUser(key, envar) {
+			*v = b
+		}
+	}
+	setString("host", "MAGDA_HOST", host)
+	if p.URL != "" && !isSetByUser("base-url", "MAGDA_URL") && !isSetByUser("host", "MAGDA_HOST") {
+		*baseURL = p.URL
+	}
+	setString("tenant-id", "MAGDA_TENANT_ID", tenantID)
+	setString("auth-id", "MAGDA_AUTH_ID", authID)
+	setString("auth-key", "MAGDA_AUTH_KEY", authKey)
+	setString("jwt-secret", "MAGDA_JWT_SECRET", jwtSecret)
+	setString("jwt-user-id", "MAGDA_JWT_USER_ID", jwtUser)
+	setBool("use-tls", "", useTLS, p.UseTLS)
+	setBool("skip-gateway", "MAGDA_SKIP_GATEWAY", skipGateway, p.SkipGateway)
+}
+
+func isSetByUser(flag string, envar string) bool {
+	return cliFlags[flag] || (envar != "" && os.Getenv(envar) != "")
+}
+
+/**** LIST ****/
+
+func cliConfigList(topCmd *kingpin.CmdClause) {
+	topCmd.Command("list", "List all profiles, marking the current one with '*'").Action(func(_ *kingpin.ParseContext) error {
+		c := Config()
+		for _, name := range c.Names() {
+			marker := " "
+			if name == c.CurrentProfile {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		return nil
+	})
+}
+
+/**** USE ****/
+
+func cliConfigUse(topCmd *kingpin.CmdClause) {
+	var name string
+	c := topCmd.Command("use", "Make a profile the current one").Action(func(_ *kingpin.ParseContext) error {
+		if err := Config().Use(name); err != nil {
+			return err
+		}
+		if err := Config().Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to profile '%s'\n", name)
+		return nil
+	})
+	c.Arg("name", "Profile name").
+		Required().
+		StringVar(&name)
+}
+
+/**** SHOW ****/
+
+func cliConfigShow(topCmd *kingpin.CmdClause) {
+	var name string
+	var showSecrets bool
+	c := topCmd.Command("show", "Show the settings of a profile").Action(func(_ *kingpin.ParseContext) error {
+		if name == "" {
+			name = *profileName
+		}
+		p, err := Config().Profile(name)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return fmt.Errorf("no current profile, use 'config use' to select one")
+		}
+		for _, key := range config.Keys {
+			v := p.Get(key)
+			if v == "" {
+				continue
+			}
+			if config.SecretKeys[key] && !showSecrets {
+				v = "REDACTED"
+			}
+			fmt.Printf("%s: %s\n", key, v)
+		}
+		return nil
+	})
+	c.Arg("name", "Profile name, defaults to the current one").
+		StringVar(&name)
+	c.Flag("show-secrets", "Also show auth key and JWT secret").
+		BoolVar(&showSecrets)
+}
+
+/**** SET ****/
+
+func cliConfigSet(topCmd *kingpin.CmdClause) {
+	var name string
+	settings := map[string]string{}
+	c := topCmd.Command("set", "Change settings of a profile, creating it if necessary").Action(func(_ *kingpin.ParseContext) error {
+		keys := make([]string, 0, len(settings))
+		for k := range settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := Config().Set(name, k, settings[k]); err != nil {
+				return err
+			}
+		}
+		if err := Config().Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully updated profile '%s'\n", name)
+		return nil
+	})
+	c.Arg("name", "Profile name").
+		Required().
+		StringVar(&name)
+	c.Arg("settings", "Settings as KEY=VALUE, an empty VALUE removes it. Keys are: host, url, tenant-id, auth-id, auth-key, use-tls, skip-gateway, jwt-secret, jwt-user-id").
+		Required().
+		StringMapVar(&settings)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	log "go.uber.org/zap"
+)
+
+func init() {
+	cliDevServer(App())
+}
+
+/**** DEV SERVER ****/
+
+func cliDevServer(app *kingpin.Application) {
+	var port int
+	var bind string
+	c := app.Command("dev-server", "Run an in-memory fake Magda registry for local development").Action(func(_ *kingpin.ParseContext) error {
+		addr := net.JoinHostPort(bind, strconv.Itoa(port))
+		handler := logRequests(fakeregistry.New(), Logger())
+		fmt.Printf("Serving fake Magda registry on http://%s - use '--host %s'\n", addr, addr)
+		return http.ListenAndServe(addr, handler)
+	})
+	c.Flag("port", "Port to listen on").
+		Short('p').
+		Default("6100").
+		IntVar(&port)
+	c.Flag("bind", "Address to bind to").
+		Default("localhost").
+		StringVar(&bind)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func logRequests(h http.Handler, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{w, http.StatusOK}
+		h.ServeHTTP(rec, req)
+		logger.Info("request",
+			log.String("method", req.Method),
+			log.String("url", req.URL.String()),
+			log.String("tenant", req.Header.Get("X-Magda-Tenant-Id")),
+			log.Int("statusCode", rec.statusCode),
+			log.Duration("duration", time.Since(start)))
+	})
+}
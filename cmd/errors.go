@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/schema"
+)
+
+// Exit codes returned by the CLI, one for each class of error
+const (
+	ExitOK              = 0
+	ExitUsage           = 1 // illegal command line or input files
+	ExitConnection      = 2 // Magda couldn't be reached
+	ExitNotFound        = 3 // 404
+	ExitUnauthorized    = 4 // 401
+	ExitForbidden       = 5 // 403
+	ExitBadRequest      = 6 // 400, e.g. validation failed
+	ExitConflict        = 7 // 409
+	ExitTooManyRequests = 8 // 429
+	ExitServerError     = 9 // any other error reported by Magda
+)
+
+var errorFormat = app.Flag("error-format", "Format of error messages written to stderr: text or json [MAGDA_ERROR_FORMAT]").
+	Default("text").Envar("MAGDA_ERROR_FORMAT").Enum("text", "json")
+
+type errorReport struct {
+	Class      string                 `json:"class"`
+	ExitCode   int                    `json:"exitCode"`
+	Message    string                 `json:"message"`
+	Method     string                 `json:"method,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+	StatusCode int                    `json:"statusCode,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// ReportError writes 'err' to stderr in the requested format and returns the
+// exit code for it.
+func ReportError(err error) int {
+	r := classifyError(err)
+	if errorFormat != nil && *errorFormat == "json" {
+		b, _ := json.Marshal(map[string]interface{}{"error": r})
+		fmt.Fprintf(os.Stderr, "%s\n", b)
+	} else {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", r.Message)
+	}
+	return r.ExitCode
+}
+
+// checkFailedError reports records which don't match the schema of their
+// aspect, after they have been listed
+type checkFailedError struct {
+	Aspect string
+	Failed int
+}
+
+func (e *checkFailedError) Error() string {
+	return fmt.Sprintf("%d record(s) with aspect '%s' don't match the schema", e.Failed, e.Aspect)
+}
+
+func classifyError(err error) *errorReport {
+	r := &errorReport{Class: "usage", ExitCode: ExitUsage, Message: err.Error()}
+	var verr *schema.ValidationError
+	if errors.As(err, &verr) {
+		// rejected before sending, as Magda would have done
+		r.Class, r.ExitCode = "invalid", ExitBadRequest
+		r.Details = map[string]interface{}{"aspect": verr.Aspect, "errors": verr.Errors}
+		return r
+	}
+	var cerr *checkFailedError
+	if errors.As(err, &cerr) {
+		r.Class, r.ExitCode = "invalid", ExitBadRequest
+		r.Details = map[string]interface{}{"aspect": cerr.Aspect, "failed": cerr.Failed}
+		return r
+	}
+	var aerr adapter.IAdapterError
+	if !errors.As(err, &aerr) {
+		return r
+	}
+	r.Method = aerr.Method()
+	r.Path = aerr.Path()
+
+	var merr *adapter.MagdaError
+	var derr *adapter.DecodeError
+	if errors.As(err, &merr) {
+		r.StatusCode = merr.StatusCode
+		r.Details = merr.Details
+	}
+	switch {
+	case errors.Is(err, adapter.ErrNotFound):
+		r.Class, r.ExitCode = "not_found", ExitNotFound
+	case errors.Is(err, adapter.ErrUnauthorized):
+		r.Class, r.ExitCode = "unauthorized", ExitUnauthorized
+	case errors.Is(err, adapter.ErrForbidden):
+		r.Class, r.ExitCode = "forbidden", ExitForbidden
+	case errors.Is(err, adapter.ErrBadRequest):
+		r.Class, r.ExitCode = "bad_request", ExitBadRequest
+	case errors.Is(err, adapter.ErrConflict):
+		r.Class, r.ExitCode = "conflict", ExitConflict
+	case errors.Is(err, adapter.ErrTooManyRequests):
+		r.Class, r.ExitCode = "too_many_requests", ExitTooManyRequests
+	case errors.Is(err, adapter.ErrMissingHost):
+		r.Class, r.ExitCode = "usage", ExitUsage
+	case merr != nil, errors.As(err, &derr):
+		r.Class, r.ExitCode = "server_error", ExitServerError
+	default:
+		r.Class, r.ExitCode = "connection", ExitConnection
+	}
+	return r
+}
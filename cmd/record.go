@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/bulk"
 	"github.com/maxott/magda-cli/pkg/record"
+	log "go.uber.org/zap"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -15,8 +26,15 @@ func init() {
 	cliRecordRead(cmd)
 	cliRecordCreate(cmd)
 	cliRecordUpdate(cmd)
+	cliRecordPatch(cmd)
+	cliRecordDiff(cmd)
 	cliRecordDelete(cmd)
 	cliRecordHistory(cmd)
+	cliRecordShowAt(cmd)
+	cliRecordRevert(cmd)
+	cliRecordImport(cmd)
+	cliRecordExport(cmd)
+	cliRecordValidate(cmd)
 }
 
 /**** LIST ****/
@@ -25,7 +43,13 @@ func cliRecordList(topCmd *kingpin.CmdClause) {
 	r := &record.ListRequest{Offset: -1, Limit: -1}
 	var andQueries []string
 	var orQueries []string
+	var all bool
+	var max int
+	var where string
 	c := topCmd.Command("list", "List some records").Action(func(_ *kingpin.ParseContext) error {
+		if err := addWhere(r, where); err != nil {
+			return err
+		}
 		rq := r.AndQuery
 		for _, q := range andQueries {
 			rq = append(rq, record.NewQueryTermS(q))
@@ -38,20 +62,30 @@ func cliRecordList(topCmd *kingpin.CmdClause) {
 		}
 		r.OrQuery = rq
 
-		if pyld, err := record.ListRaw(context.Background(), r, Adapter(), Logger()); err != nil {
+		if all {
+			return listAllRecords(r, max)
+		} else if max > 0 {
+			return fmt.Errorf("flag --max requires --all")
+		}
+		if sp, err := record.ListRawStream(context.Background(), r, Adapter(), Logger()); err != nil {
 			return err
 		} else {
-			return adapter.ReplyPrinter(pyld, *useYaml)
+			return adapter.StreamPrinter(sp, *useYaml)
 		}
 	})
 	c.Flag("aspects", "The aspects for which to retrieve data").
 		Short('a').
 		StringVar(&r.Aspects)
+	c.Flag("optional-aspects", "Further aspects to retrieve if a record has them").
+		StringVar(&r.OptionalAspects)
 	c.Flag("and-query", "Record Name").
 		Short('q').
 		StringsVar(&andQueries)
 	c.Flag("or-query", "Record Name").
 		StringsVar(&orQueries)
+	c.Flag("where", "Query expression, like 'cse-order.status = \"pending\" and cse-order.amount >= 10'").
+		Short('w').
+		StringVar(&where)
 	c.Flag("offset", "Index of first record retrieved").
 		Short('o').
 		IntVar(&r.Offset)
@@ -61,6 +95,45 @@ func cliRecordList(topCmd *kingpin.CmdClause) {
 	c.Flag("page-token", "Token that identifies the start of a page of results").
 		Short('t').
 		StringVar(&r.PageToken)
+	c.Flag("all", "Retrieve all pages and print one record per line (JSON Lines)").
+		BoolVar(&all)
+	c.Flag("max", "The maximum number of records to print with --all").
+		IntVar(&max)
+}
+
+// addWhere adds the terms of a '--where' expression to 'r'
+func addWhere(r *record.ListRequest, where string) error {
+	if where == "" {
+		return nil
+	}
+	and, or, err := record.ParseWhere(where)
+	var werr *record.WhereError
+	if errors.As(err, &werr) {
+		return fmt.Errorf("invalid --where expression - %s\n  %s\n  %s^", err, where, strings.Repeat(" ", werr.Pos))
+	} else if err != nil {
+		return err
+	}
+	r.AndQuery = append(r.AndQuery, and...)
+	r.OrQuery = append(r.OrQuery, or...)
+	return nil
+}
+
+// listAllRecords prints every record as a single line of JSON, so that the
+// output can be processed while further pages are still being retrieved.
+func listAllRecords(r *record.ListRequest, max int) error {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	it := record.ListAll(context.Background(), r, Adapter(), Logger())
+	for count := 0; (max <= 0 || count < max) && it.Next(); count++ {
+		if err := enc.Encode(it.Record()); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return it.Err()
 }
 
 /**** CREATE ****/
@@ -70,15 +143,24 @@ type CreateCmd struct {
 	Name            string         `json:"name"`
 	Aspects         record.Aspects `json:"aspects"`
 	SourceTag       string         `json:"sourceTag,omitempty"`
-	AspectName      string         `json:"-"`
+	AspectArgs      []string       `json:"-"` // NAME=FILE, or NAME with 'AspectFile' or 'AspectFromStdin'
 	AspectFile      string         `json:"-"`
 	AspectFromStdin bool           `json:"-"`
+	AspectsDir      string         `json:"-"`
+	RecordFile      string         `json:"-"`
+	NoValidate      bool           `json:"-"`
 }
 
 func cliRecordCreate(topCmd *kingpin.CmdClause) {
 	r := &CreateCmd{}
 	c := topCmd.Command("create", "Creates a new record").Action(func(_ *kingpin.ParseContext) error {
 		addAspects(r)
+		if r.Name == "" {
+			App().Fatalf("required flag --name not provided, try --help")
+		}
+		if err := validateAspects(r); err != nil {
+			return err
+		}
 		cmd := record.CreateRequest{
 			Id: r.Id, Name: r.Name, Aspects: r.Aspects, SourceTag: r.SourceTag,
 		}
@@ -94,41 +176,146 @@ func cliRecordCreate(topCmd *kingpin.CmdClause) {
 		StringVar(&r.Id)
 	c.Flag("name", "Record Name").
 		Short('n').
-		Required().
 		StringVar(&r.Name)
 	cliAddAspectFlags(r, c)
 }
 
 func cliAddAspectFlags(r *CreateCmd, c *kingpin.CmdClause) {
-	c.Flag("aspect-name", "Name of aspect to add (requires --aspectFile)").
+	c.Flag("aspect", "Aspect to add as NAME=FILE (or NAME=@FILE), can be repeated").
 		Short('a').
-		StringVar(&r.AspectName)
-	c.Flag("aspect-file", "File containing aspect data").
+		PlaceHolder("NAME=FILE").
+		StringsVar(&r.AspectArgs)
+	// before aspects could be repeated, '-a' only took the name
+	c.Flag("aspect-name", "Name of the aspect in --aspect-file or --stdin").
+		Hidden().
+		StringsVar(&r.AspectArgs)
+	c.Flag("aspect-file", "File containing the data of the aspect given by name only").
 		Short('f').
 		ExistingFileVar(&r.AspectFile)
-	c.Flag("stdin", "Read aspect data from stdin").
+	c.Flag("stdin", "Read the data of the aspect given by name only from stdin").
 		BoolVar(&r.AspectFromStdin)
+	c.Flag("aspects-dir", "Directory with one JSON or YAML file per aspect, named after the aspect").
+		ExistingDirVar(&r.AspectsDir)
+	c.Flag("record-file", "JSON or YAML file with the whole record ('id', 'name', 'sourceTag', 'aspects'), '-' for stdin").
+		StringVar(&r.RecordFile)
+	c.Flag("no-validate", "Don't check the aspects against their schemas before sending them").
+		BoolVar(&r.NoValidate)
+}
 
+// validateAspects checks all aspects of 'r' against their schemas in Magda
+func validateAspects(r *CreateCmd) error {
+	if r.NoValidate {
+		return nil
+	}
+	names := make([]string, 0, len(r.Aspects))
+	for n := range r.Aspects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := Validator().Validate(context.Background(), n, r.Aspects[n]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// addAspects collects the aspects from all sources into 'r.Aspects'. Later
+// sources override earlier ones: the record file, the aspects directory, and
+// finally the individual aspects. Id, name and source tag from the record file
+// are only used if not given as flags.
 func addAspects(r *CreateCmd) {
 	r.Aspects = record.Aspects{}
-	if r.AspectName == "" {
+	if r.RecordFile != "" {
+		if r.RecordFile == "-" && r.AspectFromStdin {
+			App().Fatalf("only one of --record-file and --stdin can read from stdin")
+		}
+		var doc record.CreateRequest
+		if err := loadObjAsType(r.RecordFile, &doc); err != nil {
+			App().Fatalf("failed to load record from '%s' - %s", r.RecordFile, err)
+		}
+		if r.Id == "" {
+			r.Id = doc.Id
+		}
+		if r.Name == "" {
+			r.Name = doc.Name
+		}
+		if r.SourceTag == "" {
+			r.SourceTag = doc.SourceTag
+		}
+		for k, v := range doc.Aspects {
+			r.Aspects[k] = v
+		}
+	}
+	if r.AspectsDir != "" {
+		aspects, err := bulk.ReadAspectsDir(r.AspectsDir)
+		if err != nil {
+			App().Fatalf("failed to load aspects from '%s' - %s", r.AspectsDir, err)
+		}
+		for k, v := range aspects {
+			r.Aspects[k] = v
+		}
+	}
+
+	bareName := ""
+	for _, a := range r.AspectArgs {
+		i := strings.Index(a, "=")
+		if i < 0 {
+			if bareName != "" {
+				App().Fatalf("only one aspect can be read from --aspect-file or --stdin, use NAME=FILE for '%s'", a)
+			}
+			bareName = a
+			continue
+		}
+		name, fileName := a[:i], strings.TrimPrefix(a[i+1:], "@")
+		if name == "" || fileName == "" {
+			App().Fatalf("flag --aspect expects 'NAME=FILE', but got '%s'", a)
+		}
+		r.Aspects[name] = loadAspectFromFile(fileName)
+	}
+	if bareName == "" {
 		if r.AspectFile != "" || r.AspectFromStdin {
-			App().Fatalf("required flag --aspect-name not provided, try --help")
+			App().Fatalf("flag --aspect-file and --stdin require the aspect name through --aspect NAME, try --help")
 		}
 		return
 	}
 
 	if r.AspectFile != "" {
-		r.Aspects[r.AspectName] = loadObjFromFile(r.AspectFile)
+		r.Aspects[bareName] = loadAspectFromFile(r.AspectFile)
 	} else if r.AspectFromStdin {
-		r.Aspects[r.AspectName] = loadObjFromStdin()
+		r.Aspects[bareName] = loadObjFromStdin()
 	} else {
-		App().Fatalf("required flag --aspect-file or --stdin not provided, try --help")
+		App().Fatalf("aspect '%s' requires a file as '%s=FILE', or flag --aspect-file or --stdin, try --help", bareName, bareName)
 	}
 }
 
+func loadAspectFromFile(fileName string) record.Aspect {
+	var obj record.Aspect
+	if err := loadObjAsType(fileName, &obj); err != nil {
+		App().Fatalf("failed to load aspect from '%s' - %s", fileName, err)
+	}
+	return obj
+}
+
+// loadObjAsType loads a JSON or YAML object from 'fileName', or stdin if '-',
+// into 'v'
+func loadObjAsType(fileName string, v interface{}) error {
+	var pld adapter.Payload
+	var err error
+	if fileName == "-" {
+		pld, err = adapter.LoadPayloadFromStdin(*useYaml)
+	} else {
+		pld, err = adapter.LoadPayloadFromFile(fileName, *useYaml || isYamlFile(fileName))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := pld.AsObject(); err != nil {
+		return err
+	}
+	return pld.AsType(v)
+}
+
 /**** READ ****/
 
 func cliRecordRead(topCmd *kingpin.CmdClause) {
@@ -155,11 +342,29 @@ func cliRecordRead(topCmd *kingpin.CmdClause) {
 
 func cliRecordUpdate(topCmd *kingpin.CmdClause) {
 	r := &CreateCmd{}
+	var merge bool
 	c := topCmd.Command("update", "Update an existing record").Action(func(_ *kingpin.ParseContext) error {
 		addAspects(r)
+		if r.Id == "" {
+			App().Fatalf("required flag --id not provided, try --help")
+		}
+		if err := validateAspects(r); err != nil {
+			return err
+		}
 		cmd := record.UpdateRequest{
 			Id: r.Id, Name: r.Name, Aspects: r.Aspects, SourceTag: r.SourceTag,
 		}
+		if merge {
+			res, err := record.Update(context.Background(), &cmd, record.MergeUpdate, Adapter(), Logger())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Successfully updated record '%s'\n", r.Id)
+			printAspectList("Added", res.Added)
+			printAspectList("Changed", res.Changed)
+			printAspectList("Unchanged", res.Unchanged)
+			return nil
+		}
 		if _, err := record.UpdateRaw(context.Background(), &cmd, Adapter(), Logger()); err == nil {
 			fmt.Printf("Successfully updated record '%s'\n", r.Id)
 			return nil
@@ -167,21 +372,341 @@ func cliRecordUpdate(topCmd *kingpin.CmdClause) {
 			return err
 		}
 	})
-	c.Flag("id", "Record ID (defaults to UUID)").
+	c.Flag("id", "Record ID, required unless given by --record-file").
 		Short('i').
-		Required().
 		StringVar(&r.Id)
 	c.Flag("name", "Record Name").
 		Short('n').
 		StringVar(&r.Name)
+	c.Flag("merge", "Only add or replace the given aspects, keeping all others").
+		BoolVar(&merge)
 	cliAddAspectFlags(r, c)
 }
 
+func printAspectList(label string, aspects []string) {
+	if len(aspects) > 0 {
+		fmt.Printf("  %s: %s\n", label, strings.Join(aspects, ", "))
+	}
+}
+
+/**** PATCH ****/
+
+func cliRecordPatch(topCmd *kingpin.CmdClause) {
+	r := &record.PatchAspectRequest{}
+	var patchFile string
+	var fromStdin bool
+	var tests, sets, appends, removes []string
+	var noValidate bool
+	c := topCmd.Command("patch", "Apply a JSON Patch (RFC 6902) to an aspect of a record").Action(func(_ *kingpin.ParseContext) error {
+		ops := []record.PatchOp{}
+		for _, t := range tests {
+			path, value := parsePatchValue("test", t)
+			ops = append(ops, record.PatchTestOp(path, value))
+		}
+		if patchFile != "" {
+			ops = append(ops, loadPatch(patchFile)...)
+		} else if fromStdin {
+			ops = append(ops, loadPatch("-")...)
+		}
+		// the current aspect decides between 'add' and 'replace' for --set,
+		// and is validated with the patch applied
+		var current interface{} // nil if the aspect doesn't exist yet
+		if len(sets) > 0 || !noValidate {
+			a, err := record.ReadAspect(context.Background(), &record.ReadRequest{Id: r.Id, Aspect: r.Aspect}, Adapter(), Logger())
+			if err == nil {
+				current = map[string]interface{}(a)
+			} else if !errors.Is(err, adapter.ErrNotFound) {
+				return err
+			}
+		}
+		doc := current
+		if d, err := record.ApplyPatch(doc, ops); err == nil {
+			doc = d
+		}
+		for _, s := range sets {
+			path, value := parsePatchValue("set", s)
+			op := record.PatchSetOp(doc, path, value)
+			ops = append(ops, op)
+			if d, err := record.ApplyPatch(doc, []record.PatchOp{op}); err == nil {
+				doc = d
+			}
+		}
+		for _, a := range appends {
+			path, value := parsePatchValue("append", a)
+			ops = append(ops, record.PatchAddOp(strings.TrimSuffix(path, "/")+"/-", value))
+		}
+		for _, path := range removes {
+			ops = append(ops, record.PatchRemoveOp(path))
+		}
+		if len(ops) == 0 {
+			App().Fatalf("no patch operations provided, try --help")
+		}
+		r.Patch = ops
+		if !noValidate {
+			if err := validatePatch(r, current); err != nil {
+				return err
+			}
+		}
+		if _, err := record.PatchAspectRaw(context.Background(), r, Adapter(), Logger()); err == nil {
+			fmt.Printf("Successfully patched aspect '%s' of record '%s'\n", r.Aspect, r.Id)
+			return nil
+		} else {
+			return err
+		}
+	})
+	c.Flag("id", "Record ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("aspect", "Name of aspect to patch").
+		Short('a').
+		Required().
+		StringVar(&r.Aspect)
+	c.Flag("file", "File containing an array of patch operations").
+		Short('f').
+		ExistingFileVar(&patchFile)
+	c.Flag("stdin", "Read array of patch operations from stdin").
+		BoolVar(&fromStdin)
+	c.Flag("test", "Only apply patch if value at PATH equals VALUE").
+		PlaceHolder("PATH=VALUE").
+		StringsVar(&tests)
+	c.Flag("set", "Set value at PATH, replacing an existing one, VALUE is JSON, a plain string, or @FILE").
+		PlaceHolder("PATH=VALUE").
+		StringsVar(&sets)
+	c.Flag("append", "Append VALUE to array at PATH").
+		PlaceHolder("PATH=VALUE").
+		StringsVar(&appends)
+	c.Flag("remove", "Remove value at PATH").
+		PlaceHolder("PATH").
+		StringsVar(&removes)
+	c.Flag("no-validate", "Don't check the patched aspect against its schema before sending the patch").
+		BoolVar(&noValidate)
+}
+
+// validatePatch applies the patch to the 'current' aspect locally, and checks
+// the result against the aspect's schema. If the aspect doesn't exist or the
+// patch can't be applied, Magda will report that.
+func validatePatch(r *record.PatchAspectRequest, current interface{}) error {
+	if current == nil {
+		return nil
+	}
+	patched, err := record.ApplyPatch(current, r.Patch)
+	if err != nil {
+		Logger().Debug("Patch doesn't apply locally, skipping validation", log.Error(err))
+		return nil
+	}
+	return Validator().Validate(context.Background(), r.Aspect, patched)
+}
+
+func loadPatch(fileName string) []record.PatchOp {
+	var pld adapter.Payload
+	var err error
+	if fileName == "-" {
+		pld, err = adapter.LoadPayloadFromStdin(*useYaml)
+	} else {
+		pld, err = adapter.LoadPayloadFromFile(fileName, *useYaml || isYamlFile(fileName))
+	}
+	if err != nil {
+		App().Fatalf("failed to load patch from '%s' - %s", fileName, err)
+	}
+	ops, err := record.ParsePatch(pld.AsBytes())
+	if err != nil {
+		App().Fatalf("failed to verify patch from '%s' - %s", fileName, err)
+	}
+	return ops
+}
+
+// parsePatchValue splits 'PATH=VALUE'. VALUE is either '@' followed by the name of a
+// JSON or YAML file, valid JSON, or otherwise taken as a string.
+func parsePatchValue(flag string, s string) (string, interface{}) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		App().Fatalf("flag --%s expects 'PATH=VALUE', but got '%s'", flag, s)
+	}
+	path, vs := s[:i], s[i+1:]
+	if strings.HasPrefix(vs, "@") {
+		fileName := vs[1:]
+		pld, err := adapter.LoadPayloadFromFile(fileName, *useYaml || isYamlFile(fileName))
+		if err != nil {
+			App().Fatalf("failed to load value from '%s' - %s", fileName, err)
+		}
+		var v interface{}
+		if err := pld.AsType(&v); err != nil {
+			App().Fatalf("failed to verify value from '%s' - %s", fileName, err)
+		}
+		return path, v
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(vs), &v); err != nil {
+		return path, vs
+	}
+	return path, v
+}
+
+func isYamlFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+/**** DIFF ****/
+
+func cliRecordDiff(topCmd *kingpin.CmdClause) {
+	r := &record.ReadRequest{}
+	var localFile string
+	var showPatch, apply, noColor, noValidate bool
+	c := topCmd.Command("diff", "Compare a local aspect file with the aspect in Magda").Action(func(_ *kingpin.ParseContext) error {
+		ctxt := context.Background()
+		pld, err := record.ReadRaw(ctxt, r, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		var remote interface{}
+		if err := pld.AsType(&remote); err != nil {
+			return err
+		}
+		var local interface{}
+		if err := loadObjAsType(localFile, &local); err != nil {
+			App().Fatalf("failed to load aspect from '%s' - %s", localFile, err)
+		}
+
+		changes := record.Diff(remote, local)
+		if showPatch {
+			if err := printPatch(record.DiffPatch(remote, local)); err != nil {
+				return err
+			}
+		} else {
+			if len(changes) == 0 {
+				fmt.Println("No differences")
+			}
+			printChanges(changes, "", !noColor && isTerminal(os.Stdout))
+		}
+		if !apply || len(changes) == 0 {
+			return nil
+		}
+		if !noValidate {
+			if err := Validator().Validate(ctxt, r.Aspect, local); err != nil {
+				return err
+			}
+		}
+		cmd := &record.PatchAspectRequest{Id: r.Id, Aspect: r.Aspect, Patch: record.DiffPatch(remote, local)}
+		if _, err := record.PatchAspectRaw(ctxt, cmd, Adapter(), Logger()); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Successfully patched aspect '%s' of record '%s'\n", r.Aspect, r.Id)
+		return nil
+	})
+	c.Flag("id", "Record ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("aspect", "Name of aspect to compare").
+		Short('a').
+		Required().
+		StringVar(&r.Aspect)
+	c.Flag("file", "File containing the local version of the aspect").
+		Short('f').
+		Required().
+		ExistingFileVar(&localFile)
+	c.Flag("patch", "Print the JSON Patch instead of a human readable diff").
+		BoolVar(&showPatch)
+	c.Flag("apply", "Send the patch to Magda").
+		BoolVar(&apply)
+	c.Flag("no-validate", "Don't check the local aspect against its schema before applying the patch").
+		BoolVar(&noValidate)
+	c.Flag("no-color", "Don't color the diff").
+		BoolVar(&noColor)
+}
+
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// printChanges prints every change on a line starting with 'indent'
+func printChanges(changes []record.Change, indent string, useColor bool) {
+	color := func(c string) string {
+		if useColor {
+			return c
+		}
+		return ""
+	}
+	for _, c := range changes {
+		switch c.Op {
+		case "add":
+			fmt.Printf("%s%s+ %s: %s%s\n", indent, color(colorGreen), c.Path, compactJSON(c.NewValue), color(colorReset))
+		case "remove":
+			fmt.Printf("%s%s- %s: %s%s\n", indent, color(colorRed), c.Path, compactJSON(c.OldValue), color(colorReset))
+		default:
+			fmt.Printf("%s%s~ %s: %s -> %s%s\n", indent, color(colorYellow), c.Path,
+				compactJSON(c.OldValue), compactJSON(c.NewValue), color(colorReset))
+		}
+	}
+}
+
+func printPatch(ops []record.PatchOp) error {
+	return printValue(ops)
+}
+
+// printValue prints 'v' like a reply from Magda
+func printValue(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	pld, err := adapter.LoadPayloadFromBytes(b, false)
+	if err != nil {
+		return err
+	}
+	return adapter.ReplyPrinter(pld, *useYaml)
+}
+
+func compactJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 /**** DELETE ****/
 
 func cliRecordDelete(topCmd *kingpin.CmdClause) {
 	r := &record.DeleteRequest{}
-	c := topCmd.Command("delete", "Delete a record or one of it's aspects").Action(func(_ *kingpin.ParseContext) error {
+	l := &record.ListRequest{Offset: -1, Limit: -1}
+	var andQueries, orQueries []string
+	var dryRun, yes bool
+	var workers int
+	var where string
+	c := topCmd.Command("delete", "Delete a record or one of it's aspects, or all records matching a query").Action(func(_ *kingpin.ParseContext) error {
+		isQuery := len(andQueries) > 0 || len(orQueries) > 0 || l.Aspects != "" || where != ""
+		if r.Id != "" && isQuery {
+			return fmt.Errorf("use either --id or a query, but not both")
+		} else if r.Id == "" && !isQuery {
+			return fmt.Errorf("requires --id, or --where, --and-query, --or-query or --aspects to select records")
+		}
+		if isQuery {
+			if err := addWhere(l, where); err != nil {
+				return err
+			}
+			for _, q := range andQueries {
+				l.AndQuery = append(l.AndQuery, record.NewQueryTermS(q))
+			}
+			for _, q := range orQueries {
+				l.OrQuery = append(l.OrQuery, record.NewQueryTermS(q))
+			}
+			return deleteMatching(l, r.AspectName, dryRun, yes, workers)
+		}
+		if dryRun {
+			fmt.Printf("Would delete record '%s'\n", r.Id)
+			return nil
+		}
 		if _, err := record.DeleteRaw(context.Background(), r, Adapter(), Logger()); err == nil {
 			fmt.Printf("Successfully deleted record '%s'\n", r.Id)
 			return nil
@@ -191,11 +716,105 @@ func cliRecordDelete(topCmd *kingpin.CmdClause) {
 	})
 	c.Flag("id", "Record ID").
 		Short('i').
-		Required().
 		StringVar(&r.Id)
 	c.Flag("aspect", "Only delete this aspect").
 		Short('a').
 		StringVar(&r.AspectName)
+	c.Flag("aspects", "Delete all records with these aspects").
+		StringVar(&l.Aspects)
+	c.Flag("and-query", "Delete all records matching all of these queries").
+		Short('q').
+		StringsVar(&andQueries)
+	c.Flag("or-query", "Delete all records matching any of these queries").
+		StringsVar(&orQueries)
+	c.Flag("where", "Delete all records matching this query expression").
+		StringVar(&where)
+	c.Flag("dry-run", "Only show what would be deleted").
+		BoolVar(&dryRun)
+	c.Flag("yes", "Don't ask for confirmation").
+		BoolVar(&yes)
+	c.Flag("workers", "Number of concurrent calls to Magda").
+		Default("4").
+		IntVar(&workers)
+}
+
+// number of matching record IDs shown before deleting
+const deleteSampleSize = 10
+
+func deleteMatching(l *record.ListRequest, aspect string, dryRun bool, yes bool, workers int) error {
+	if aspect != "" {
+		// only records which have the aspect to delete
+		if l.Aspects == "" {
+			l.Aspects = aspect
+		} else {
+			l.Aspects = l.Aspects + "," + aspect
+		}
+	}
+	ctxt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ids, err := bulk.MatchingIDs(ctxt, l, Adapter(), Logger())
+	if err != nil {
+		return err
+	}
+	what := fmt.Sprintf("%d records", len(ids))
+	if aspect != "" {
+		what = fmt.Sprintf("aspect '%s' of %d records", aspect, len(ids))
+	}
+	if len(ids) == 0 {
+		fmt.Println("No matching records")
+		return nil
+	}
+	fmt.Printf("%d matching records:\n", len(ids))
+	for i, id := range ids {
+		if i == deleteSampleSize {
+			fmt.Printf("  ... and %d more\n", len(ids)-i)
+			break
+		}
+		fmt.Printf("  %s\n", id)
+	}
+	if dryRun {
+		fmt.Printf("Would delete %s\n", what)
+		return nil
+	}
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("not deleting without confirmation, use --yes")
+		}
+		fmt.Printf("Delete %s? [y/N] ", what)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing deleted")
+			return nil
+		}
+	}
+
+	progress := newProgress("Deleted")
+	summary, err := bulk.Delete(ctxt, &bulk.DeleteRequest{
+		IDs:     ids,
+		Aspect:  aspect,
+		Workers: workers,
+		OnResult: func(res *bulk.Result) {
+			if res.Error != nil {
+				progress.add("failed")
+				fmt.Fprintf(os.Stderr, "\rFailed to delete '%s' - %s\n", res.ID, res.Error)
+			} else {
+				progress.add("ok")
+			}
+		},
+	}, Adapter(), Logger())
+	progress.done()
+	if aspect != "" {
+		fmt.Printf("Deleted aspect '%s' of %d records, %d failed\n", aspect, summary.Succeeded, summary.Failed)
+	} else {
+		fmt.Printf("Deleted %d records, %d failed\n", summary.Succeeded, summary.Failed)
+	}
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("failed to delete %d records", summary.Failed)
+	}
+	return nil
 }
 
 /**** HISTORY ****/
@@ -203,10 +822,10 @@ func cliRecordDelete(topCmd *kingpin.CmdClause) {
 func cliRecordHistory(topCmd *kingpin.CmdClause) {
 	r := &record.HistoryRequest{Offset: -1, Limit: -1}
 	c := topCmd.Command("history", "Get a list of all events for a record").Action(func(_ *kingpin.ParseContext) error {
-		if pyld, err := record.HistoryRaw(context.Background(), r, Adapter(), Logger()); err != nil {
+		if sp, err := record.HistoryRawStream(context.Background(), r, Adapter(), Logger()); err != nil {
 			return err
 		} else {
-			return adapter.ReplyPrinter(pyld, *useYaml)
+			return adapter.StreamPrinter(sp, *useYaml)
 		}
 	})
 	c.Flag("id", "Record ID").
@@ -226,3 +845,116 @@ func cliRecordHistory(topCmd *kingpin.CmdClause) {
 		Short('t').
 		StringVar(&r.PageToken)
 }
+
+/**** REPLAY ****/
+
+func cliRecordShowAt(topCmd *kingpin.CmdClause) {
+	r := &record.ReadAtRequest{}
+	var at string
+	c := topCmd.Command("show-at", "Show a record as it was at an event or time, rebuilt from its history").Action(func(_ *kingpin.ParseContext) error {
+		if (r.EventId == 0) == (at == "") {
+			return fmt.Errorf("requires either --event-id or --time")
+		}
+		if at != "" {
+			t, err := time.Parse(time.RFC3339, at)
+			if err != nil {
+				return fmt.Errorf("invalid --time '%s', expected e.g. '2021-06-11T01:59:35Z' - %s", at, err)
+			}
+			r.Time = t
+		}
+		rec, last, err := record.ReadAt(context.Background(), r, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Record '%s' after event %d (%s) at %s\n", r.Id, last.ID, last.EventType, last.EventTime.Format(time.RFC3339))
+		return printValue(rec)
+	})
+	c.Flag("id", "Record ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("event-id", "Include all events up to this one").
+		Short('e').
+		Int64Var(&r.EventId)
+	c.Flag("time", "Include all events up to this time (RFC 3339)").
+		Short('t').
+		StringVar(&at)
+}
+
+func cliRecordRevert(topCmd *kingpin.CmdClause) {
+	r := &record.ReadAtRequest{}
+	var yes, noColor bool
+	c := topCmd.Command("revert", "Restore a record to its state after an event").Action(func(_ *kingpin.ParseContext) error {
+		ctxt := context.Background()
+		target, _, err := record.ReadAt(ctxt, r, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		current, err := readWithAllAspects(ctxt, r.Id, target)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			fmt.Printf("Record '%s' doesn't exist and will be created\n", r.Id)
+		} else {
+			changes := record.DiffRecord(current, target)
+			if len(changes) == 0 {
+				fmt.Println("No differences")
+				return nil
+			}
+			printChanges(changes, "", !noColor && isTerminal(os.Stdout))
+		}
+		if !yes {
+			fmt.Println("\nRun again with --yes to revert these changes")
+			return nil
+		}
+		if current == nil {
+			cr := &record.CreateRequest{Id: target.ID, Name: target.Name, SourceTag: target.SourceTag, Aspects: record.Aspects{}}
+			for name, a := range target.Aspects {
+				if obj, ok := a.(map[string]interface{}); ok {
+					cr.Aspects[name] = obj
+				}
+			}
+			_, err = record.CreateRaw(ctxt, cr, Adapter(), Logger())
+		} else {
+			err = record.Restore(ctxt, current, target, Adapter(), Logger())
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Successfully reverted record '%s' to event %d\n", r.Id, r.EventId)
+		return nil
+	})
+	c.Flag("id", "Record ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("to-event", "ID of the event after which to restore the record").
+		Short('e').
+		Required().
+		Int64Var(&r.EventId)
+	c.Flag("yes", "Apply the changes").
+		BoolVar(&yes)
+	c.Flag("no-color", "Don't color the diff").
+		BoolVar(&noColor)
+}
+
+// readWithAllAspects returns record 'id' with all its aspects, and those of
+// 'other', or nil if it doesn't exist
+func readWithAllAspects(ctxt context.Context, id string, other *record.Record) (*record.Record, error) {
+	sum, err := record.ReadSummary(ctxt, &record.ReadRequest{Id: id}, Adapter(), Logger())
+	if errors.Is(err, adapter.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	aspects := append([]string{}, sum.Aspects...)
+	for name := range other.Aspects {
+		aspects = append(aspects, name)
+	}
+	rec, err := record.Read(ctxt, &record.ReadRequest{Id: id, OptionalAspects: strings.Join(aspects, ",")}, Adapter(), Logger())
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/maxott/magda-cli/pkg/bulk"
+	"github.com/maxott/magda-cli/pkg/record"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+/**** EXPORT ****/
+
+func cliRecordExport(topCmd *kingpin.CmdClause) {
+	r := &bulk.ExportRequest{List: record.ListRequest{Offset: -1, Limit: -1}}
+	var andQueries, orQueries []string
+	var output, format, where string
+	c := topCmd.Command("export", "Write all matching records to JSON Lines, a directory tree or a tar archive").Action(func(_ *kingpin.ParseContext) error {
+		if err := addWhere(&r.List, where); err != nil {
+			return err
+		}
+		for _, q := range andQueries {
+			r.List.AndQuery = append(r.List.AndQuery, record.NewQueryTermS(q))
+		}
+		for _, q := range orQueries {
+			r.List.OrQuery = append(r.List.OrQuery, record.NewQueryTermS(q))
+		}
+		sink, err := bulk.FileSink(output, format)
+		if err != nil {
+			App().Fatalf("failed to create '%s' - %s", output, err)
+		}
+		r.Sink = sink
+
+		ctxt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		count, err := bulk.Export(ctxt, r, Adapter(), Logger())
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if output != "-" {
+			fmt.Fprintf(os.Stderr, "Exported %d records to '%s'\n", count, output)
+		}
+		return nil
+	})
+	c.Flag("output", "File or directory to write to, '-' for stdout").
+		Short('o').
+		Default("-").
+		StringVar(&output)
+	c.Flag("format", "Output format, derived from the output name by default: 'jsonl' for '-', '.jsonl' and '.json', 'tar' (gzipped) for '.tar.gz' and '.tgz', and 'dir' otherwise").
+		EnumVar(&format, bulk.FormatJSONLines, bulk.FormatDir, bulk.FormatTar)
+	c.Flag("aspects", "The aspects a record needs to have to be exported. Without any aspects given, all defined aspects are exported").
+		Short('a').
+		StringVar(&r.List.Aspects)
+	c.Flag("optional-aspects", "Further aspects to export if a record has them").
+		StringVar(&r.List.OptionalAspects)
+	c.Flag("and-query", "Query all records need to match, like 'aspect.path:value'").
+		Short('q').
+		StringsVar(&andQueries)
+	c.Flag("or-query", "Query any of which records need to match").
+		StringsVar(&orQueries)
+	c.Flag("where", "Query expression records need to match, like 'cse-order.status = \"done\"'").
+		StringVar(&where)
+	c.Flag("limit", "The number of records to retrieve per page").
+		Short('l').
+		IntVar(&r.List.Limit)
+	c.Flag("max", "The maximum number of records to export").
+		IntVar(&r.Max)
+	c.Flag("workers", "Number of records written concurrently").
+		Short('w').
+		Default("4").
+		IntVar(&r.Workers)
+}
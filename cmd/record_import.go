@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/maxott/magda-cli/pkg/bulk"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+/**** IMPORT ****/
+
+type importReportLine struct {
+	Key    string `json:"key"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func cliRecordImport(topCmd *kingpin.CmdClause) {
+	r := &bulk.ImportRequest{}
+	var fileName, mappingFile, reportFile, checkpointFile string
+	c := topCmd.Command("import", "Create many records from JSON Lines, a CSV file or a directory tree").Action(func(_ *kingpin.ParseContext) error {
+		var mapping *bulk.CSVMapping
+		if mappingFile != "" {
+			var err error
+			if mapping, err = bulk.LoadCSVMapping(mappingFile); err != nil {
+				App().Fatalf("failed to load mapping '%s' - %s", mappingFile, err)
+			}
+		}
+		src, closeSrc, err := bulk.FileSource(fileName, mapping)
+		if err != nil {
+			App().Fatalf("%s", err)
+		}
+		defer closeSrc()
+		r.Source = src
+
+		if checkpointFile != "" {
+			cp, err := bulk.OpenCheckpoint(checkpointFile)
+			if err != nil {
+				App().Fatalf("failed to open checkpoint '%s' - %s", checkpointFile, err)
+			}
+			defer cp.Close()
+			r.Checkpoint = cp
+		}
+		var report *json.Encoder
+		if reportFile != "" {
+			f, err := os.Create(reportFile)
+			if err != nil {
+				App().Fatalf("failed to create report '%s' - %s", reportFile, err)
+			}
+			defer f.Close()
+			report = json.NewEncoder(f)
+		}
+
+		progress := newProgress("Imported")
+		r.OnResult = func(res *bulk.Result) {
+			line := importReportLine{Key: res.Key, ID: res.ID, Status: "ok"}
+			if res.Skipped {
+				line.Status = "skipped"
+			} else if res.Error != nil {
+				line.Status, line.Error = "failed", res.Error.Error()
+			}
+			if report != nil {
+				report.Encode(&line)
+			}
+			progress.add(line.Status)
+		}
+
+		// stop gracefully on Ctrl-C, the checkpoint allows for resuming later
+		ctxt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		summary, err := bulk.Import(ctxt, r, Adapter(), Logger())
+		progress.done()
+		fmt.Printf("Imported %d records, %d failed, %d skipped\n", summary.Succeeded, summary.Failed, summary.Skipped)
+		if err != nil {
+			return err
+		}
+		if summary.Failed > 0 {
+			return fmt.Errorf("failed to import %d records", summary.Failed)
+		}
+		return nil
+	})
+	c.Arg("source", "JSON Lines file (one record per line), CSV file (requires --mapping), or directory with '<recordId>/<aspect>.json' files").
+		Required().
+		StringVar(&fileName)
+	c.Flag("mapping", "JSON or YAML file mapping CSV columns to record fields").
+		Short('m').
+		ExistingFileVar(&mappingFile)
+	c.Flag("update", "Create or replace records instead of failing on existing ones").
+		BoolVar(&r.Update)
+	c.Flag("workers", "Number of concurrent calls to Magda").
+		Short('w').
+		Default("4").
+		IntVar(&r.Workers)
+	c.Flag("report", "Write the result for every record to FILE (JSON Lines)").
+		PlaceHolder("FILE").
+		StringVar(&reportFile)
+	c.Flag("checkpoint", "Keep track of imported records in FILE and skip them when run again").
+		PlaceHolder("FILE").
+		StringVar(&checkpointFile)
+}
+
+// progress reports the number of records processed to stderr, if it is a terminal
+type progress struct {
+	verb   string
+	counts map[string]int
+	total  int
+	show   bool
+}
+
+func newProgress(verb string) *progress {
+	return &progress{verb: verb, counts: map[string]int{}, show: isTerminal(os.Stderr)}
+}
+
+func (p *progress) add(status string) {
+	p.counts[status]++
+	p.total++
+	if p.show {
+		fmt.Fprintf(os.Stderr, "\r%s %d records, %d failed, %d skipped", p.verb, p.counts["ok"], p.counts["failed"], p.counts["skipped"])
+	}
+}
+
+func (p *progress) done() {
+	if p.show && p.total > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/maxott/magda-cli/pkg/schema"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+/**** VALIDATE ****/
+
+func cliRecordValidate(topCmd *kingpin.CmdClause) {
+	var aspect, fileName, schemaFile string
+	var fromStdin bool
+	c := topCmd.Command("validate", "Check aspect data against the schema of its aspect definition").Action(func(_ *kingpin.ParseContext) error {
+		var data map[string]interface{}
+		source := "'" + fileName + "'"
+		if fileName != "" {
+			data = loadAspectFromFile(fileName)
+		} else if fromStdin {
+			data, source = loadObjFromStdin(), "stdin"
+		} else {
+			App().Fatalf("required flag --file or --stdin not provided, try --help")
+		}
+
+		var err error
+		if schemaFile != "" {
+			// offline
+			var s *schema.Schema
+			if s, err = schema.Compile(aspect, loadObjFromFile(schemaFile)); err != nil {
+				return err
+			}
+			err = s.Validate(data)
+		} else {
+			err = Validator().Validate(context.Background(), aspect, data)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Aspect '%s' in %s is valid\n", aspect, source)
+		return nil
+	})
+	c.Flag("aspect", "Name of the aspect").
+		Short('a').
+		Required().
+		StringVar(&aspect)
+	c.Flag("file", "File containing the aspect data").
+		Short('f').
+		ExistingFileVar(&fileName)
+	c.Flag("stdin", "Read the aspect data from stdin").
+		BoolVar(&fromStdin)
+	c.Flag("schema", "Local JSON schema file to check against, instead of the aspect definition in Magda").
+		PlaceHolder("FILE").
+		ExistingFileVar(&schemaFile)
+}
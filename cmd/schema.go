@@ -2,9 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
 
 	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/bulk"
 	"github.com/maxott/magda-cli/pkg/schema"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -15,6 +20,8 @@ func init() {
 	cliSchemaCreate(cmd)
 	cliSchemaRead(cmd)
 	cliSchemaUpdate(cmd)
+	cliSchemaDiff(cmd)
+	cliSchemaCheckRecords(cmd)
 }
 
 /**** LIST ****/
@@ -46,6 +53,7 @@ func cliSchemaCreate(topCmd *kingpin.CmdClause) {
 			Id: r.Id, Name: r.Name, Schema: loadObjFromFile(r.SchemaFile),
 		}
 		if _, err := schema.CreateRaw(context.Background(), &cmd, Adapter(), Logger()); err == nil {
+			Validator().Forget(r.Id)
 			fmt.Printf("Successfully create schema '%s'\n", r.Id)
 			return nil
 		} else {
@@ -93,11 +101,27 @@ func cliSchemaRead(topCmd *kingpin.CmdClause) {
 
 func cliSchemaUpdate(topCmd *kingpin.CmdClause) {
 	r := &SchemaCreate{}
+	var force bool
 	c := topCmd.Command("update", "Update existing schema").Action(func(_ *kingpin.ParseContext) error {
 		cmd := schema.UpdateRequest{
 			Id: r.Id, Name: r.Name, Schema: loadSchema(r),
 		}
+		current, err := schema.Read(context.Background(), &schema.ReadRequest{Id: r.Id}, Adapter(), Logger())
+		if err != nil && !errors.Is(err, adapter.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			if cmd.Name == "" {
+				cmd.Name = current.Name
+			}
+			breaking := schema.Breaking(schema.CompareSchemas(current.JSONSchema, cmd.Schema))
+			if len(breaking) > 0 && !force {
+				printSchemaChanges(breaking, "  ", isTerminal(os.Stderr), os.Stderr)
+				return fmt.Errorf("refusing to update schema '%s' with %d breaking change(s), use --force to update anyway", r.Id, len(breaking))
+			}
+		}
 		if _, err := schema.UpdateRaw(context.Background(), &cmd, Adapter(), Logger()); err == nil {
+			Validator().Forget(r.Id)
 			fmt.Printf("Successfully updated schema '%s'\n", r.Id)
 			return nil
 		} else {
@@ -107,6 +131,8 @@ func cliSchemaUpdate(topCmd *kingpin.CmdClause) {
 	c.Flag("name", "Descriptive name").
 		Short('n').
 		StringVar(&r.Name)
+	c.Flag("force", "Update even if existing data may no longer match the schema").
+		BoolVar(&force)
 	cliAddSchemaCUFlags(r, c)
 }
 
@@ -121,6 +147,121 @@ func loadSchema(r *SchemaCreate) map[string]interface{} {
 	}
 }
 
+/**** DIFF ****/
+
+func cliSchemaDiff(topCmd *kingpin.CmdClause) {
+	r := &SchemaCreate{}
+	var noColor bool
+	c := topCmd.Command("diff", "Compare a local schema with the one in Magda, and classify the changes as compatible or breaking").Action(func(_ *kingpin.ParseContext) error {
+		local := loadSchema(r)
+		current, err := schema.Read(context.Background(), &schema.ReadRequest{Id: r.Id}, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		changes := schema.CompareSchemas(current.JSONSchema, local)
+		if len(changes) == 0 {
+			fmt.Println("No differences")
+			return nil
+		}
+		printSchemaChanges(changes, "  ", !noColor && isTerminal(os.Stdout), os.Stdout)
+		fmt.Printf("\n%d change(s), %d breaking\n", len(changes), len(schema.Breaking(changes)))
+		return nil
+	})
+	c.Flag("id", "Schema ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("schema-file", "File containing the new schema declaration").
+		Short('f').
+		ExistingFileVar(&r.SchemaFile)
+	c.Flag("stdin", "Read the new schema definition from stdin").
+		BoolVar(&r.SchemaFromStdin)
+	c.Flag("no-color", "Don't color the changes").
+		BoolVar(&noColor)
+}
+
+func printSchemaChanges(changes []schema.SchemaChange, indent string, useColor bool, out *os.File) {
+	for _, c := range changes {
+		label, color := "compatible", colorGreen
+		if c.Breaking {
+			label, color = "breaking", colorRed
+		}
+		if !useColor {
+			color = ""
+		}
+		reset := ""
+		if color != "" {
+			reset = colorReset
+		}
+		fmt.Fprintf(out, "%s%s%-10s %s: %s%s\n", indent, color, label, c.Path, c.Message, reset)
+	}
+}
+
+/**** CHECK RECORDS ****/
+
+func cliSchemaCheckRecords(topCmd *kingpin.CmdClause) {
+	r := &SchemaCreate{}
+	var limit int
+	var format string
+	c := topCmd.Command("check-records", "Report the records whose aspect would not match a new schema").Action(func(_ *kingpin.ParseContext) error {
+		s, err := schema.Compile(r.Id, loadSchema(r))
+		if err != nil {
+			return err
+		}
+		ctxt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		report, err := bulk.Check(ctxt, &bulk.CheckRequest{Aspect: r.Id, Schema: s, Limit: limit}, Adapter(), Logger())
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			if err := printValue(report); err != nil {
+				return err
+			}
+		} else {
+			printCheckReport(report)
+		}
+		if len(report.Failed) > 0 {
+			return &checkFailedError{Aspect: report.Aspect, Failed: len(report.Failed)}
+		}
+		return nil
+	})
+	c.Flag("id", "Schema ID").
+		Short('i').
+		Required().
+		StringVar(&r.Id)
+	c.Flag("schema-file", "File containing the new schema declaration").
+		Short('f').
+		ExistingFileVar(&r.SchemaFile)
+	c.Flag("stdin", "Read the new schema definition from stdin").
+		BoolVar(&r.SchemaFromStdin)
+	c.Flag("limit", "Only check the first N records, for a sample of large registries").
+		Short('l').
+		IntVar(&limit)
+	c.Flag("format", "Format of the report: table or json").
+		Default("table").
+		EnumVar(&format, "table", "json")
+}
+
+func printCheckReport(report *bulk.CheckReport) {
+	if len(report.Failed) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RECORD\tPATH\tERROR")
+		for _, f := range report.Failed {
+			for _, e := range f.Errors {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", f.ID, e.Pointer, e.Message)
+			}
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("Checked %d records with aspect '%s', %d would fail", report.Checked, report.Aspect, len(report.Failed))
+	if !report.Complete {
+		fmt.Print(" (stopped at --limit)")
+	}
+	fmt.Println()
+}
+
 /**** DELETE ****/
 
 // Not supported
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+var (
+	traceExporter = app.Flag("trace-exporter", "Export OpenTelemetry spans of registry calls to: none, stdout or otlp [MAGDA_TRACE_EXPORTER]").
+			Default("none").Envar("MAGDA_TRACE_EXPORTER").Enum("none", "stdout", "otlp")
+	otlpEndpoint = app.Flag("otlp-endpoint", "host:port of OTLP/HTTP collector [MAGDA_OTLP_ENDPOINT]").
+			Default("localhost:4318").Envar("MAGDA_OTLP_ENDPOINT").String()
+	otlpInsecure = app.Flag("otlp-insecure", "Connect to OTLP collector without TLS [MAGDA_OTLP_INSECURE]").
+			Default("true").Envar("MAGDA_OTLP_INSECURE").Bool()
+
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// tracingOption returns the adapter option to trace all registry calls, or
+// nil if tracing isn't enabled.
+func tracingOption() adapter.Option {
+	if *traceExporter == "none" {
+		return nil
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch *traceExporter {
+	case "stdout":
+		// keep stdout clean for the command's output
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(*otlpEndpoint)}
+		if *otlpInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	}
+	if err != nil {
+		App().Fatalf("failed to set up trace exporter - %s", err)
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("magda-cli"))
+	// spans are exported synchronously, as the CLI may exit at any time
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithResource(res))
+	return adapter.WithTracing(tracerProvider)
+}
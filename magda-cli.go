@@ -6,7 +6,6 @@ import (
 	"os"
 
 	"github.com/maxott/magda-cli/cmd"
-	"github.com/maxott/magda-cli/pkg/adapter"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -23,15 +22,21 @@ func main() {
 	app.Flag("debug", "Be very chatty [MAGDA_DEBUG]").Short('d').Envar("MAGDA_DEBUG").Action(setDebug).Bool()
 	app.Flag("version", "Print out version").Action(printVersion).Bool()
 
+	// App().Fatalf exits through here, save the recorded session anyway
+	app.Terminate(func(code int) {
+		cmd.Shutdown()
+		cmd.Logger().Sync()
+		os.Exit(code)
+	})
+
 	// app.PreAction(configLogger)
-	_, err := app.Parse(os.Args[1:])
-	if err != nil {
-		//var e interface{} = err
-		if aerr, ok := err.(adapter.IAdapterError); ok {
-			fmt.Printf("ERROR: %s\n", aerr.Error())
-		}
+	exitCode := cmd.ExitOK
+	if _, err := app.Parse(os.Args[1:]); err != nil {
+		exitCode = cmd.ReportError(err)
 	}
+	cmd.Shutdown()
 	cmd.Logger().Sync()
+	os.Exit(exitCode)
 }
 
 func setLogger(logLevel zapcore.Level) {
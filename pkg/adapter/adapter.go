@@ -2,8 +2,8 @@
 package adapter
 
 import (
+	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -15,12 +15,16 @@ import (
 
 type ConnectionCtxt struct {
 	Host        string
+	URL         string // full base URL, e.g. 'https://corp.example/magda', overrides Host and UseTLS
 	TenantID    string
 	AuthID      string
 	AuthKey     string
 	JwtToken    string
 	UseTLS      bool
 	SkipGateway bool
+	BasePaths   BasePaths
+	Retry       RetryPolicy
+	Transport   TransportCtxt
 }
 
 func CreateJwtToken(userID *string, signingSecret *string) (string, error) {
@@ -31,110 +35,232 @@ func CreateJwtToken(userID *string, signingSecret *string) (string, error) {
 	return token.SignedString([]byte(*signingSecret))
 }
 
-func RestAdapter(connCtxt ConnectionCtxt) Adapter {
-	return restAdapter{connCtxt}
-}
-
-type IAdapterError interface {
-	Error() string
-	Path() string
-}
-type AdapterError struct {
-	path string
-}
-
-func (e *AdapterError) Path() string { return e.path }
-
-func (e *AdapterError) Error() string { return "Generic magda adapter error" }
-
-type MissingHostError struct {
-	AdapterError
-}
-
-func (e MissingHostError) Error() string { return "Missing host name" }
-
-type ResourceNotFoundError struct {
-	AdapterError
-}
-
-func (e ResourceNotFoundError) Error() string { return "Resource not found" }
-
-type UnauthorizedError struct {
-	AdapterError
-}
-
-func (e *UnauthorizedError) Error() string { return "Unauthorized access" }
-
-type MagdaError struct {
-	AdapterError
-	StatusCode int
-	Message    string
+// RestAdapter returns an adapter talking to the Magda server described by
+// 'connCtxt'. Errors in the transport settings are reported by every call.
+// Use NewRestAdapter to catch them early.
+func RestAdapter(connCtxt ConnectionCtxt, opts ...Option) Adapter {
+	a, err := NewRestAdapter(connCtxt, opts...)
+	if err != nil {
+		return restAdapter{ctxt: connCtxt, err: err}
+	}
+	return a
 }
 
-func (e *MagdaError) Error() string { return e.Message }
-
-type ClientError struct {
-	AdapterError
-	err error
+func NewRestAdapter(connCtxt ConnectionCtxt, opts ...Option) (Adapter, error) {
+	if _, err := connCtxt.baseURL(); err != nil {
+		return nil, err
+	}
+	client, err := newHTTPClient(&connCtxt.Transport)
+	if err != nil {
+		return nil, err
+	}
+	return newRestAdapter(connCtxt, client, opts), nil
 }
 
-func (e *ClientError) Error() string {
-	return fmt.Sprintf("while connecting to Magda registry - %s", e.err.Error())
+func newRestAdapter(connCtxt ConnectionCtxt, client *http.Client, opts []Option) Adapter {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	client.Transport = chainTransport(client.Transport, o.transports)
+	return Chain(restAdapter{connCtxt, client, nil}, o.middlewares...)
 }
 
 type restAdapter struct {
-	ctxt ConnectionCtxt
+	ctxt   ConnectionCtxt
+	client *http.Client
+	err    error // set when 'client' couldn't be created
 }
 
 func (a restAdapter) Get(ctxt context.Context, path string, logger *log.Logger) (Payload, error) {
-	return connect(ctxt, "GET", path, nil, &a.ctxt, logger)
+	return a.connect(ctxt, "GET", path, nil, logger)
 }
 
 func (a restAdapter) Post(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
-	return connect(ctxt, "POST", path, body, &a.ctxt, logger)
+	return a.connect(ctxt, "POST", path, body, logger)
 }
 
 func (a restAdapter) Put(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
-	return connect(ctxt, "PUT", path, body, &a.ctxt, logger)
+	return a.connect(ctxt, "PUT", path, body, logger)
 }
 
 func (a restAdapter) Patch(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
-	return connect(ctxt, "PATCH", path, body, &a.ctxt, logger)
+	return a.connect(ctxt, "PATCH", path, body, logger)
 }
 
 func (a restAdapter) Delete(ctxt context.Context, path string, logger *log.Logger) (Payload, error) {
-	return connect(ctxt, "DELETE", path, nil, &a.ctxt, logger)
+	return a.connect(ctxt, "DELETE", path, nil, logger)
 }
 
 func (a restAdapter) SkipGateway() bool {
 	return a.ctxt.SkipGateway
 }
 
-func connect(
+func (a restAdapter) BasePath(api API) string {
+	return a.ctxt.BasePaths.resolve(a.ctxt.SkipGateway).get(api)
+}
+
+func (a restAdapter) GetStream(ctxt context.Context, path string, logger *log.Logger) (StreamPayload, error) {
+	// only waiting for the headers is limited, the body may take as long as it needs
+	resp, logger, err := a.do(ctxt, "GET", path, nil, 0, logger)
+	if err != nil {
+		return nil, err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	logger.Debug("Streaming", log.String("content-type", contentType))
+	return &streamPayload{body: resp.Body, contentType: contentType}, nil
+}
+
+func (a *restAdapter) connect(
 	ctxt context.Context,
 	method string,
 	path string,
 	body io.Reader,
-	connCtxt *ConnectionCtxt,
 	logger *log.Logger,
 ) (Payload, error) {
+	resp, logger, err := a.do(ctxt, method, path, body, a.ctxt.Transport.timeout(), logger)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Warn("Accessing response body failed.", log.Error(err))
+		return nil, &ClientError{AdapterError{method, path}, err}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	return ToPayload(respBody, contentType, logger)
+}
+
+// do sends the request, retrying it according to the adapter's retry policy.
+// If 'limit' is set, each attempt, including reading the response body, needs
+// to finish within it. On success, the caller is responsible for closing the
+// body of the returned response. All other responses are turned into errors.
+func (a *restAdapter) do(
+	ctxt context.Context,
+	method string,
+	path string,
+	body io.Reader,
+	limit time.Duration,
+	logger *log.Logger,
+) (*http.Response, *log.Logger, error) {
+	connCtxt := &a.ctxt
 	logger = logger.With(log.String("method", method), log.String("path", path))
-	if connCtxt.Host == "" {
-		logger.Error("Missing 'host'")
-		return nil, &MissingHostError{AdapterError{path}}
+	if a.err != nil {
+		logger.Error("Setting up http client", log.Error(a.err))
+		return nil, logger, &ClientError{AdapterError{method, path}, a.err}
 	}
-	protocol := "http://"
-	if connCtxt.UseTLS {
-		protocol = "https://"
+	baseURL, err := connCtxt.baseURL()
+	if err != nil {
+		logger.Error("Illegal 'url'", log.Error(err))
+		return nil, logger, &ClientError{AdapterError{method, path}, err}
 	}
-	url := protocol + connCtxt.Host + path
+	if baseURL == "" {
+		logger.Error("Missing 'host'")
+		return nil, logger, &MissingHostError{AdapterError{method, path}}
+	}
+	url := baseURL + path
 	logger = logger.With(log.String("url", url))
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		logger.Error("Creating http request", log.Error(err))
-		return nil, &ClientError{AdapterError{path}, err}
+
+	// buffer the body so that it can be replayed on retry
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		if bodyBytes, err = ioutil.ReadAll(body); err != nil {
+			logger.Error("Reading request body", log.Error(err))
+			return nil, logger, &ClientError{AdapterError{method, path}, err}
+		}
+	}
+
+	policy := connCtxt.Retry
+	canRetry := policy.canRetry(method)
+	client := a.client
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		var reqBody io.Reader
+		if bodyBytes != nil {
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		reqCtxt, cancel := ctxt, context.CancelFunc(func() {})
+		if limit > 0 {
+			reqCtxt, cancel = context.WithTimeout(ctxt, limit)
+		}
+		req, err := http.NewRequestWithContext(reqCtxt, method, url, reqBody)
+		if err != nil {
+			cancel()
+			logger.Error("Creating http request", log.Error(err))
+			return nil, logger, &ClientError{AdapterError{method, path}, err}
+		}
+		setHeaders(req, connCtxt)
+
+		logger.Debug("Calling magda registry", log.Int("attempt", attempt))
+		resp, err = client.Do(req)
+		if err != nil {
+			cancel()
+			if canRetry && attempt < policy.MaxAttempts && isRetryableError(ctxt, err) {
+				delay := policy.backoff(attempt)
+				logger.Warn("HTTP request failed, retrying", log.Error(err),
+					log.Int("attempt", attempt), log.Duration("delay", delay))
+				if err2 := sleep(ctxt, delay); err2 != nil {
+					return nil, logger, &ClientError{AdapterError{method, path}, err2}
+				}
+				continue
+			}
+			logger.Warn("HTTP request failed.", log.Error(err))
+			return nil, logger, &ClientError{AdapterError{method, path}, err}
+		}
+		resp.Body = &cancelingBody{resp.Body, cancel}
+
+		if canRetry && attempt < policy.MaxAttempts && isRetryableStatus(resp.StatusCode) {
+			// drain body to allow for the connection to be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			delay := policy.backoff(attempt)
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				if d, ok := policy.retryAfter(resp); ok {
+					delay = d
+				}
+			}
+			logger.Warn("HTTP response, retrying", log.Int("statusCode", resp.StatusCode),
+				log.Int("attempt", attempt), log.Duration("delay", delay))
+			if err = sleep(ctxt, delay); err != nil {
+				return nil, logger, &ClientError{AdapterError{method, path}, err}
+			}
+			continue
+		}
+		break
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			logger.Warn("Accessing response body failed.", log.Error(err))
+			return nil, logger, &ClientError{AdapterError{method, path}, err}
+		}
+		if len(respBody) > 0 {
+			logger = logger.With(log.ByteString("body", respBody))
+		}
+		logger.Warn("HTTP response", log.Int("statusCode", resp.StatusCode))
+		return nil, logger, newMagdaError(method, path, resp.StatusCode, respBody)
 	}
+	return resp, logger, nil
+}
+
+// cancelingBody releases the deadline of a request once its body is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
 
+func setHeaders(req *http.Request, connCtxt *ConnectionCtxt) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Cache-Control", "no-cache")
 	if connCtxt.TenantID != "" {
@@ -149,42 +275,18 @@ func connect(
 	if connCtxt.JwtToken != "" {
 		req.Header.Set("X-Magda-Session", connCtxt.JwtToken)
 	}
+}
 
-	client := &http.Client{Timeout: time.Second * 10}
-	logger.Debug("Calling magda registry")
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Warn("HTTP request failed.", log.Error(err))
-		return nil, &ClientError{AdapterError{path}, err}
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
+// GetAs unmarshals the response to 'path' into 'res'. Unlike ignoring the error
+// of Payload.AsType, it reports responses not matching 'res'.
+func GetAs(ctxt context.Context, adpt Adapter, path string, res interface{}, logger *log.Logger) error {
+	pyl, err := adpt.Get(ctxt, path, logger)
 	if err != nil {
-		logger.Warn("Accessing response body failed.", log.Error(err))
-		return nil, &ClientError{AdapterError{path}, err}
+		return err
 	}
-
-	if resp.StatusCode >= 300 {
-		if len(respBody) > 0 {
-			logger = logger.With(log.ByteString("body", respBody))
-		}
-		logger.Warn("HTTP response", log.Int("statusCode", resp.StatusCode))
-		switch resp.StatusCode {
-		case http.StatusNotFound:
-			return nil, &ResourceNotFoundError{AdapterError{path}}
-		case http.StatusUnauthorized:
-			return nil, &UnauthorizedError{AdapterError{path}}
-		default:
-			return nil, &MagdaError{
-				AdapterError{path},
-				resp.StatusCode,
-				string(respBody),
-			}
-		}
-
-		//ResourceNotFoundError
+	if err := pyl.AsType(res); err != nil {
+		logger.Warn("Decoding response", log.String("path", path), log.Error(err))
+		return &DecodeError{AdapterError{http.MethodGet, path}, err}
 	}
-	contentType := resp.Header.Get("Content-Type")
-	return ToPayload(respBody, contentType, logger)
+	return nil
 }
@@ -0,0 +1,332 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A Cassette holds request/response pairs recorded from a real Magda server.
+// They can later be replayed to test code built on this library offline,
+// or to capture CLI sessions for bug reports.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+
+	// Headers whose values are replaced by "REDACTED" when recording
+	Redact []string `json:"-" yaml:"-"`
+
+	fileName string
+	used     []bool
+	changed  bool // interactions recorded since last saved
+	mu       sync.Mutex
+}
+
+type Interaction struct {
+	Request  CassetteRequest  `json:"request" yaml:"request"`
+	Response CassetteResponse `json:"response" yaml:"response"`
+}
+
+type CassetteRequest struct {
+	Method  string            `json:"method" yaml:"method"`
+	Path    string            `json:"path" yaml:"path"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string            `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	StatusCode  int    `json:"statusCode" yaml:"statusCode"`
+	ContentType string `json:"contentType,omitempty" yaml:"contentType,omitempty"`
+	Body        string `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+var DefaultRedactedHeaders = []string{
+	"X-Magda-API-Key", "X-Magda-API-Key-Id", "X-Magda-Session", "Authorization", "Cookie",
+}
+
+// NewCassette creates an empty cassette which will be saved to 'fileName'.
+// It is saved as YAML if the file name ends in '.yaml' or '.yml', otherwise as JSON.
+func NewCassette(fileName string) *Cassette {
+	return &Cassette{fileName: fileName, Redact: DefaultRedactedHeaders}
+}
+
+func LoadCassette(fileName string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCassette(fileName)
+	if isYAMLFile(fileName) {
+		err = yaml.Unmarshal(data, c)
+	} else {
+		err = json.Unmarshal(data, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing cassette '%s' - %s", fileName, err)
+	}
+	return c, nil
+}
+
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+// Close saves the cassette if any interactions have been recorded into it
+// since it was last saved
+func (c *Cassette) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	return c.save()
+}
+
+func (c *Cassette) save() (err error) {
+	var data []byte
+	if isYAMLFile(c.fileName) {
+		data, err = yaml.Marshal(c)
+	} else {
+		data, err = json.MarshalIndent(c, "", "  ")
+	}
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(c.fileName, data, 0644); err == nil {
+		c.changed = false
+	}
+	return
+}
+
+func isYAMLFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+/**** MATCHING ****/
+
+// MatchRule decides if a recorded request can be replayed for an actual one
+type MatchRule func(actual *CassetteRequest, recorded *CassetteRequest) bool
+
+func MatchMethod(actual *CassetteRequest, recorded *CassetteRequest) bool {
+	return actual.Method == recorded.Method
+}
+
+// MatchPath compares path and query
+func MatchPath(actual *CassetteRequest, recorded *CassetteRequest) bool {
+	return actual.Path == recorded.Path
+}
+
+// MatchBody compares bodies, and if both are JSON, ignores formatting and the order of fields
+func MatchBody(actual *CassetteRequest, recorded *CassetteRequest) bool {
+	if actual.Body == recorded.Body {
+		return true
+	}
+	var a, r interface{}
+	if json.Unmarshal([]byte(actual.Body), &a) != nil || json.Unmarshal([]byte(recorded.Body), &r) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, r)
+}
+
+// MatchHeader requires header 'name' to be identical
+func MatchHeader(name string) MatchRule {
+	return func(actual *CassetteRequest, recorded *CassetteRequest) bool {
+		return actual.Headers[name] == recorded.Headers[name]
+	}
+}
+
+var DefaultMatchRules = []MatchRule{MatchMethod, MatchPath, MatchBody}
+
+// find returns the first unused interaction matching 'req'. If all matching
+// interactions have already been replayed, the last one of them is used again.
+func (c *Cassette) find(req *CassetteRequest, rules []MatchRule) *Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.used) < len(c.Interactions) {
+		c.used = append(c.used, false)
+	}
+	var reuse *Interaction
+	for i, in := range c.Interactions {
+		matched := true
+		for _, rule := range rules {
+			if !rule(req, &in.Request) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return in
+		}
+		reuse = in
+	}
+	return reuse
+}
+
+/**** ADAPTERS ****/
+
+// NewRecordingAdapter returns an adapter which talks to the Magda server described
+// by 'connCtxt' and records every request/response pair into 'cassette'. Close the
+// cassette when done to save it.
+func NewRecordingAdapter(connCtxt ConnectionCtxt, cassette *Cassette, opts ...Option) (Adapter, error) {
+	// recording needs to be the innermost transport to capture the actual request
+	opts = append(opts, WithTransport(cassette.Recorder))
+	return NewRestAdapter(connCtxt, opts...)
+}
+
+// Recorder is a transport middleware recording every request/response pair
+// passing through it into the cassette.
+func (c *Cassette) Recorder(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{next, c}
+}
+
+// ReplayAdapter returns an adapter which serves all requests from 'cassette'
+// without touching the network. A request is matched against the recorded ones
+// with 'rules' (defaults to DefaultMatchRules). Only the path prefix of URL,
+// SkipGateway, BasePaths and TenantID of 'connCtxt' are relevant.
+func ReplayAdapter(connCtxt ConnectionCtxt, cassette *Cassette, rules ...MatchRule) Adapter {
+	if len(rules) == 0 {
+		rules = DefaultMatchRules
+	}
+	if connCtxt.Host == "" {
+		connCtxt.Host = "cassette"
+	}
+	client := &http.Client{Transport: &replayTransport{cassette, rules}}
+	return newRestAdapter(connCtxt, client, nil)
+}
+
+type recordingTransport struct {
+	inner    http.RoundTripper
+	cassette *Cassette
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creq, err := toCassetteRequest(req, t.cassette.Redact)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	in := &Interaction{
+		Request: *creq,
+		Response: CassetteResponse{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	// record the body while the caller reads it, so streams stay streams
+	resp.Body = &recordingBody{ReadCloser: resp.Body, cassette: t.cassette, interaction: in}
+	return resp, nil
+}
+
+// recordingBody copies a response body as it is read and adds the
+// interaction to the cassette once the body is read to the end or closed.
+type recordingBody struct {
+	io.ReadCloser
+	cassette    *Cassette
+	interaction *Interaction
+	buf         bytes.Buffer
+	done        bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.record()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.record()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) record() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.interaction.Response.Body = b.buf.String()
+
+	c := b.cassette
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, b.interaction)
+	c.changed = true
+}
+
+type replayTransport struct {
+	cassette *Cassette
+	rules    []MatchRule
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creq, err := toCassetteRequest(req, t.cassette.Redact)
+	if err != nil {
+		return nil, err
+	}
+	in := t.cassette.find(creq, t.rules)
+	if in == nil {
+		return nil, fmt.Errorf("no recorded interaction found for %s %s", creq.Method, creq.Path)
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+		StatusCode:    in.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader(in.Response.Body)),
+		ContentLength: int64(len(in.Response.Body)),
+		Request:       req,
+	}
+	if in.Response.ContentType != "" {
+		resp.Header.Set("Content-Type", in.Response.ContentType)
+	}
+	return resp, nil
+}
+
+func toCassetteRequest(req *http.Request, redact []string) (*CassetteRequest, error) {
+	creq := &CassetteRequest{
+		Method:  req.Method,
+		Path:    req.URL.RequestURI(),
+		Headers: map[string]string{},
+	}
+	for name := range req.Header {
+		creq.Headers[name] = req.Header.Get(name)
+	}
+	for _, name := range redact {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := creq.Headers[name]; ok {
+			creq.Headers[name] = "REDACTED"
+		}
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		creq.Body = string(body)
+	}
+	return creq, nil
+}
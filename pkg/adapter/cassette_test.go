@@ -0,0 +1,111 @@
+package adapter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"method":"` + r.Method + `","echo":` + string(body) + `}`))
+	}))
+	fileName := filepath.Join(t.TempDir(), "session.yaml")
+	connCtxt := ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://"), AuthKey: "secret"}
+	logger := log.NewNop()
+
+	recorded := NewCassette(fileName)
+	rec, err := NewRecordingAdapter(connCtxt, recorded)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := rec.Post(context.Background(), "/foo?a=1", strings.NewReader(`{"x": 1}`), logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := rec.Get(context.Background(), "/missing", logger); err == nil {
+		t.Fatalf("expected error")
+	}
+	srv.Close()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("expected cassette to be only saved on close")
+	}
+	if err := recorded.Close(); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(fileName)
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("auth key not redacted in\n%s", data)
+	}
+
+	cassette, err := LoadCassette(fileName)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(cassette.Interactions) != 2 {
+		t.Fatalf("expected 2 interactions, but got %d", len(cassette.Interactions))
+	}
+	replay := ReplayAdapter(ConnectionCtxt{}, cassette)
+	pld, err := replay.Post(context.Background(), "/foo?a=1", strings.NewReader(`{ "x" : 1 }`), logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if obj, _ := pld.AsObject(); obj["method"] != "POST" {
+		t.Fatalf("unexpected payload '%s'", pld.AsBytes())
+	}
+	if _, err := replay.Get(context.Background(), "/missing", logger); err == nil {
+		t.Fatalf("expected error")
+	} else if _, ok := err.(*ResourceNotFoundError); !ok {
+		t.Fatalf("expected ResourceNotFoundError, but got %T", err)
+	}
+	if _, err := replay.Get(context.Background(), "/foo?a=1", logger); err == nil {
+		t.Fatalf("expected error for unrecorded request")
+	}
+}
+
+func TestCassetteRecordStream(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[1,`))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte(`2]`))
+	}))
+	defer srv.Close()
+	cassette := NewCassette(filepath.Join(t.TempDir(), "session.json"))
+	rec, err := NewRecordingAdapter(ConnectionCtxt{URL: srv.URL}, cassette)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	// the stream needs to be handed over before the server finished the body
+	sp, err := GetStream(context.Background(), rec, "/records", log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(cassette.Interactions) != 0 {
+		t.Fatalf("expected interaction to be recorded only once the body is read")
+	}
+	close(release)
+	if b, err := ioutil.ReadAll(sp); err != nil || string(b) != "[1,2]" {
+		t.Fatalf("unexpected stream %q - %v", b, err)
+	}
+	sp.Close()
+	if len(cassette.Interactions) != 1 || cassette.Interactions[0].Response.Body != "[1,2]" {
+		t.Fatalf("unexpected interactions %+v", cassette.Interactions)
+	}
+}
@@ -0,0 +1,184 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors to test for a class of error with 'errors.Is'
+var (
+	ErrMissingHost     = errors.New("missing host name")
+	ErrNotFound        = errors.New("resource not found")
+	ErrUnauthorized    = errors.New("unauthorized access")
+	ErrForbidden       = errors.New("access forbidden")
+	ErrBadRequest      = errors.New("bad request")
+	ErrConflict        = errors.New("conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+type IAdapterError interface {
+	Error() string
+	Method() string
+	Path() string
+}
+type AdapterError struct {
+	method string
+	path   string
+}
+
+func (e *AdapterError) Method() string { return e.method }
+
+func (e *AdapterError) Path() string { return e.path }
+
+func (e *AdapterError) Error() string { return "Generic magda adapter error" }
+
+type MissingHostError struct {
+	AdapterError
+}
+
+func (e MissingHostError) Error() string { return "Missing host name" }
+
+func (e *MissingHostError) Is(target error) bool { return target == ErrMissingHost }
+
+type ClientError struct {
+	AdapterError
+	err error
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("while connecting to Magda registry - %s", e.err.Error())
+}
+
+func (e *ClientError) Unwrap() error { return e.err }
+
+// DecodeError is returned when a response from Magda can't be unmarshalled
+// into the expected type
+type DecodeError struct {
+	AdapterError
+	err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s %s - unexpected response - %s", e.method, e.path, e.err.Error())
+}
+
+func (e *DecodeError) Unwrap() error { return e.err }
+
+// MagdaError is returned for any response from Magda with a status code of
+// 300 or above. The more common ones are reported as one of the more specific
+// errors below, which all unwrap to a MagdaError.
+type MagdaError struct {
+	AdapterError
+	StatusCode int
+	Message    string                 // message extracted from the response body, or the body itself
+	Details    map[string]interface{} // the response body, if it was a JSON object
+	Body       string                 // the raw response body
+}
+
+func (e *MagdaError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s - %d %s", e.method, e.path, e.StatusCode, msg)
+}
+
+type ResourceNotFoundError struct {
+	MagdaError
+}
+
+func (e ResourceNotFoundError) Error() string { return e.describe("Resource not found") }
+
+type UnauthorizedError struct {
+	MagdaError
+}
+
+func (e *UnauthorizedError) Error() string { return e.describe("Unauthorized access") }
+
+type ForbiddenError struct {
+	MagdaError
+}
+
+func (e *ForbiddenError) Error() string { return e.describe("Access forbidden") }
+
+type BadRequestError struct {
+	MagdaError
+}
+
+func (e *BadRequestError) Error() string { return e.describe("Bad request") }
+
+type ConflictError struct {
+	MagdaError
+}
+
+func (e *ConflictError) Error() string { return e.describe("Conflict") }
+
+type TooManyRequestsError struct {
+	MagdaError
+}
+
+func (e *TooManyRequestsError) Error() string { return e.describe("Too many requests") }
+
+func (e *ResourceNotFoundError) Unwrap() error { return &e.MagdaError }
+func (e *UnauthorizedError) Unwrap() error     { return &e.MagdaError }
+func (e *ForbiddenError) Unwrap() error        { return &e.MagdaError }
+func (e *BadRequestError) Unwrap() error       { return &e.MagdaError }
+func (e *ConflictError) Unwrap() error         { return &e.MagdaError }
+func (e *TooManyRequestsError) Unwrap() error  { return &e.MagdaError }
+
+func (e *ResourceNotFoundError) Is(target error) bool { return target == ErrNotFound }
+func (e *UnauthorizedError) Is(target error) bool     { return target == ErrUnauthorized }
+func (e *ForbiddenError) Is(target error) bool        { return target == ErrForbidden }
+func (e *BadRequestError) Is(target error) bool       { return target == ErrBadRequest }
+func (e *ConflictError) Is(target error) bool         { return target == ErrConflict }
+func (e *TooManyRequestsError) Is(target error) bool  { return target == ErrTooManyRequests }
+
+func (e *MagdaError) describe(what string) string {
+	s := fmt.Sprintf("%s (%s %s)", what, e.method, e.path)
+	if e.Message != "" {
+		s = s + " - " + e.Message
+	}
+	return s
+}
+
+// newMagdaError creates the error matching 'statusCode' and extracts
+// the error message from 'body'
+func newMagdaError(method string, path string, statusCode int, body []byte) error {
+	me := MagdaError{
+		AdapterError: AdapterError{method, path},
+		StatusCode:   statusCode,
+		Body:         string(body),
+		Message:      strings.TrimSpace(string(body)),
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal(body, &details); err == nil {
+		me.Details = details
+		me.Message = ""
+		// Magda services aren't consistent in how they report errors
+		for _, k := range []string{"message", "errorMessage", "error"} {
+			if m, ok := details[k].(string); ok && m != "" {
+				me.Message = m
+				break
+			}
+		}
+	}
+	switch statusCode {
+	case http.StatusNotFound:
+		return &ResourceNotFoundError{me}
+	case http.StatusUnauthorized:
+		return &UnauthorizedError{me}
+	case http.StatusForbidden:
+		return &ForbiddenError{me}
+	case http.StatusBadRequest:
+		return &BadRequestError{me}
+	case http.StatusConflict:
+		return &ConflictError{me}
+	case http.StatusTooManyRequests:
+		return &TooManyRequestsError{me}
+	default:
+		return &me
+	}
+}
@@ -0,0 +1,53 @@
+package adapter
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestMagdaErrors(t *testing.T) {
+	cases := []struct {
+		statusCode int
+		sentinel   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusBadRequest, ErrBadRequest},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusTooManyRequests, ErrTooManyRequests},
+		{http.StatusInternalServerError, nil},
+	}
+	for _, c := range cases {
+		err := newMagdaError("PUT", "/foo", c.statusCode, []byte(`{"message": "went wrong", "code": 7}`))
+		if c.sentinel != nil && !errors.Is(err, c.sentinel) {
+			t.Errorf("%d: expected error to be '%v'", c.statusCode, c.sentinel)
+		}
+		if errors.Is(err, ErrConflict) && c.sentinel != ErrConflict {
+			t.Errorf("%d: unexpected match with ErrConflict", c.statusCode)
+		}
+		var me *MagdaError
+		if !errors.As(err, &me) {
+			t.Fatalf("%d: expected MagdaError, but got %T", c.statusCode, err)
+		}
+		if me.StatusCode != c.statusCode || me.Message != "went wrong" || me.Details["code"] != 7.0 {
+			t.Errorf("%d: unexpected content %+v", c.statusCode, me)
+		}
+		var ae IAdapterError
+		if !errors.As(err, &ae) || ae.Method() != "PUT" || ae.Path() != "/foo" {
+			t.Errorf("%d: unexpected method or path in %v", c.statusCode, err)
+		}
+	}
+}
+
+func TestMagdaErrorPlainBody(t *testing.T) {
+	err := newMagdaError("GET", "/foo", http.StatusBadGateway, []byte("upstream down\n"))
+	me, ok := err.(*MagdaError)
+	if !ok || me.Message != "upstream down" || me.Details != nil {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	if err.Error() != "GET /foo - 502 upstream down" {
+		t.Fatalf("unexpected message '%s'", err.Error())
+	}
+}
@@ -0,0 +1,203 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+// Middleware wraps an adapter to add behaviour to all of its calls
+type Middleware func(Adapter) Adapter
+
+// TransportMiddleware wraps the HTTP transport of a RestAdapter. Use it for
+// anything which needs access to the actual HTTP request or response, such as
+// adding headers or signing requests.
+type TransportMiddleware func(http.RoundTripper) http.RoundTripper
+
+// Option configures a RestAdapter
+type Option func(*options)
+
+type options struct {
+	middlewares []Middleware
+	transports  []TransportMiddleware
+}
+
+// WithMiddleware installs adapter middlewares. The first one is the outermost,
+// which means it is called first.
+func WithMiddleware(m ...Middleware) Option {
+	return func(o *options) {
+		o.middlewares = append(o.middlewares, m...)
+	}
+}
+
+// WithTransport installs transport middlewares. The first one is the outermost.
+func WithTransport(m ...TransportMiddleware) Option {
+	return func(o *options) {
+		o.transports = append(o.transports, m...)
+	}
+}
+
+// WithHeader adds header 'name' to every request
+func WithHeader(name string, value string) Option {
+	return WithTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set(name, value)
+			return next.RoundTrip(req)
+		})
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// Chain wraps 'a' with all 'middlewares', the first one being the outermost
+func Chain(a Adapter, middlewares ...Middleware) Adapter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		a = middlewares[i](a)
+	}
+	return a
+}
+
+func chainTransport(t http.RoundTripper, transports []TransportMiddleware) http.RoundTripper {
+	for i := len(transports) - 1; i >= 0; i-- {
+		t = transports[i](t)
+	}
+	return t
+}
+
+/**** INTERCEPTORS ****/
+
+// Call describes a single call to an adapter as seen by an Interceptor
+type Call struct {
+	Method string
+	Path   string
+	Body   []byte  // request body, nil for GET & DELETE
+	Stream bool    // true if the response is streamed (see StreamingAdapter)
+	Result Payload // set after 'next' returned successfully, nil for streams
+}
+
+// Interceptor is called around every call of an adapter and needs to call
+// 'next' to actually proceed with it. The context passed to 'next' is handed
+// on to the wrapped adapter.
+type Interceptor func(ctxt context.Context, call *Call, logger *log.Logger, next func(ctxt context.Context) error) error
+
+// Intercept turns 'i' into a middleware, which saves implementing all the
+// methods of Adapter for cross-cutting concerns like logging or metrics.
+func Intercept(i Interceptor) Middleware {
+	return func(a Adapter) Adapter {
+		return &interceptedAdapter{a, i}
+	}
+}
+
+type interceptedAdapter struct {
+	inner       Adapter
+	interceptor Interceptor
+}
+
+func (a *interceptedAdapter) Get(ctxt context.Context, path string, logger *log.Logger) (Payload, error) {
+	return a.call(ctxt, &Call{Method: http.MethodGet, Path: path}, logger)
+}
+
+func (a *interceptedAdapter) Post(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
+	return a.callWithBody(ctxt, http.MethodPost, path, body, logger)
+}
+
+func (a *interceptedAdapter) Put(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
+	return a.callWithBody(ctxt, http.MethodPut, path, body, logger)
+}
+
+func (a *interceptedAdapter) Patch(ctxt context.Context, path string, body io.Reader, logger *log.Logger) (Payload, error) {
+	return a.callWithBody(ctxt, http.MethodPatch, path, body, logger)
+}
+
+func (a *interceptedAdapter) Delete(ctxt context.Context, path string, logger *log.Logger) (Payload, error) {
+	return a.call(ctxt, &Call{Method: http.MethodDelete, Path: path}, logger)
+}
+
+func (a *interceptedAdapter) SkipGateway() bool {
+	return a.inner.SkipGateway()
+}
+
+func (a *interceptedAdapter) BasePath(api API) string {
+	return BasePath(a.inner, api)
+}
+
+func (a *interceptedAdapter) GetStream(ctxt context.Context, path string, logger *log.Logger) (StreamPayload, error) {
+	var sp StreamPayload
+	call := &Call{Method: http.MethodGet, Path: path, Stream: true}
+	err := a.interceptor(ctxt, call, logger, func(ctxt context.Context) (err error) {
+		sp, err = GetStream(ctxt, a.inner, path, logger)
+		return
+	})
+	if err != nil && sp != nil {
+		sp.Close()
+		sp = nil
+	}
+	return sp, err
+}
+
+func (a *interceptedAdapter) callWithBody(ctxt context.Context, method string, path string, body io.Reader, logger *log.Logger) (Payload, error) {
+	call := &Call{Method: method, Path: path, Body: []byte{}}
+	if body != nil {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, &ClientError{AdapterError{method, path}, err}
+		}
+		call.Body = b
+	}
+	return a.call(ctxt, call, logger)
+}
+
+func (a *interceptedAdapter) call(ctxt context.Context, call *Call, logger *log.Logger) (Payload, error) {
+	err := a.interceptor(ctxt, call, logger, func(ctxt context.Context) (err error) {
+		var pld Payload
+		switch call.Method {
+		case http.MethodGet:
+			pld, err = a.inner.Get(ctxt, call.Path, logger)
+		case http.MethodPost:
+			pld, err = a.inner.Post(ctxt, call.Path, bytes.NewReader(call.Body), logger)
+		case http.MethodPut:
+			pld, err = a.inner.Put(ctxt, call.Path, bytes.NewReader(call.Body), logger)
+		case http.MethodPatch:
+			pld, err = a.inner.Patch(ctxt, call.Path, bytes.NewReader(call.Body), logger)
+		case http.MethodDelete:
+			pld, err = a.inner.Delete(ctxt, call.Path, logger)
+		}
+		call.Result = pld
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call.Result, nil
+}
+
+// Logging logs every call at info level, and request and response bodies
+// at debug level.
+func Logging() Middleware {
+	return Intercept(func(ctxt context.Context, call *Call, logger *log.Logger, next func(ctxt context.Context) error) error {
+		logger = logger.With(log.String("method", call.Method), log.String("path", call.Path))
+		if len(call.Body) > 0 {
+			logger.Debug("Request body", log.ByteString("body", call.Body))
+		}
+		start := time.Now()
+		err := next(ctxt)
+		duration := log.Duration("duration", time.Since(start))
+		if err != nil {
+			logger.Info("Magda call failed", duration, log.Error(err))
+			return err
+		}
+		logger.Info("Magda call", duration)
+		if call.Result != nil {
+			logger.Debug("Response body", log.ByteString("body", call.Result.AsBytes()))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,65 @@
+package adapter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var seen []string
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, "server:"+r.Header.Get("X-Test"))
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte(`{"id":"r1"}`))
+	}))
+	defer srv.Close()
+
+	trace := func(name string) Middleware {
+		return Intercept(func(ctxt context.Context, call *Call, logger *log.Logger, next func(context.Context) error) error {
+			seen = append(seen, name+":"+call.Method)
+			err := next(ctxt)
+			if call.Result != nil {
+				seen = append(seen, name+":done")
+			}
+			return err
+		})
+	}
+	a := RestAdapter(ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://")},
+		WithMiddleware(trace("a"), trace("b")),
+		WithHeader("X-Test", "hello"),
+	)
+	pld, err := a.Post(context.Background(), "/records", strings.NewReader(`{"id":"r1"}`), log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if obj, _ := pld.AsObject(); obj["id"] != "r1" {
+		t.Fatalf("unexpected payload %s", pld.AsBytes())
+	}
+	if body != `{"id":"r1"}` {
+		t.Fatalf("request body not passed on - '%s'", body)
+	}
+	if s := strings.Join(seen, ","); s != "a:POST,b:POST,server:hello,b:done,a:done" {
+		t.Fatalf("unexpected call sequence %s", s)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	a := RestAdapter(ConnectionCtxt{}, WithMiddleware(Intercept(
+		func(ctxt context.Context, call *Call, logger *log.Logger, next func(context.Context) error) error {
+			return &ClientError{AdapterError{call.Method, call.Path}, context.Canceled}
+		})))
+	if _, err := a.Get(context.Background(), "/records", log.NewNop()); err == nil {
+		t.Fatalf("expected error from interceptor")
+	}
+	if _, err := GetStream(context.Background(), a, "/records", log.NewNop()); err == nil {
+		t.Fatalf("expected error from interceptor")
+	}
+}
@@ -0,0 +1,107 @@
+package adapter
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// API identifies one of the Magda APIs
+type API string
+
+const (
+	RegistryAPI API = "registry"
+	SearchAPI   API = "search"
+	HooksAPI    API = "hooks"
+)
+
+// BasePaths overrides the path prefixes under which the Magda APIs are
+// served. Empty ones fall back to the defaults, which depend on whether the
+// gateway is skipped. If only Registry is set, Hooks defaults to 'Registry/hooks'.
+type BasePaths struct {
+	Registry string
+	Search   string
+	Hooks    string
+}
+
+// DefaultBasePaths returns the paths of the Magda APIs, either behind the
+// gateway or when talking to the registry directly.
+func DefaultBasePaths(skipGateway bool) BasePaths {
+	if skipGateway {
+		return BasePaths{Registry: "/v0", Search: "/api/v0/search", Hooks: "/v0/hooks"}
+	}
+	return BasePaths{Registry: "/api/v0/registry", Search: "/api/v0/search", Hooks: "/api/v0/registry/hooks"}
+}
+
+// BasePathProvider is implemented by adapters which know where the Magda APIs
+// are served. Use BasePath to fall back to the defaults for all others.
+type BasePathProvider interface {
+	BasePath(api API) string
+}
+
+// BasePath returns the path prefix of 'api' for requests sent through 'adpt'
+func BasePath(adpt Adapter, api API) string {
+	if p, ok := adpt.(BasePathProvider); ok {
+		return p.BasePath(api)
+	}
+	return BasePaths{}.resolve(adpt.SkipGateway()).get(api)
+}
+
+// resolve fills in all empty paths
+func (p BasePaths) resolve(skipGateway bool) BasePaths {
+	def := DefaultBasePaths(skipGateway)
+	if p.Hooks == "" && p.Registry != "" {
+		p.Hooks = strings.TrimSuffix(p.Registry, "/") + "/hooks"
+	}
+	if p.Registry == "" {
+		p.Registry = def.Registry
+	}
+	if p.Search == "" {
+		p.Search = def.Search
+	}
+	if p.Hooks == "" {
+		p.Hooks = def.Hooks
+	}
+	return p
+}
+
+func (p BasePaths) get(api API) string {
+	var path string
+	switch api {
+	case RegistryAPI:
+		path = p.Registry
+	case SearchAPI:
+		path = p.Search
+	case HooksAPI:
+		path = p.Hooks
+	}
+	if path = strings.Trim(path, "/"); path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// baseURL returns scheme, host and path prefix all paths are appended to
+func (c *ConnectionCtxt) baseURL() (string, error) {
+	if c.URL == "" {
+		if c.Host == "" {
+			return "", nil
+		}
+		protocol := "http://"
+		if c.UseTLS {
+			protocol = "https://"
+		}
+		return protocol + c.Host, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("illegal URL '%s' - %s", c.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("illegal URL '%s' - needs to start with 'http://' or 'https://' followed by a host", c.URL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("illegal URL '%s' - can't contain a query or fragment", c.URL)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
@@ -0,0 +1,59 @@
+package adapter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestBasePaths(t *testing.T) {
+	cases := []struct {
+		paths       BasePaths
+		skipGateway bool
+		api         API
+		expected    string
+	}{
+		{BasePaths{}, false, RegistryAPI, "/api/v0/registry"},
+		{BasePaths{}, true, RegistryAPI, "/v0"},
+		{BasePaths{}, true, HooksAPI, "/v0/hooks"},
+		{BasePaths{}, true, SearchAPI, "/api/v0/search"},
+		{BasePaths{Registry: "registry/"}, false, HooksAPI, "/registry/hooks"},
+		{BasePaths{Registry: "/reg", Hooks: "/hooks"}, true, HooksAPI, "/hooks"},
+		{BasePaths{Search: "/"}, false, SearchAPI, ""},
+	}
+	for _, c := range cases {
+		a := RestAdapter(ConnectionCtxt{SkipGateway: c.skipGateway, BasePaths: c.paths})
+		if p := BasePath(a, c.api); p != c.expected {
+			t.Errorf("expected '%s' for %s of %+v, but got '%s'", c.expected, c.api, c.paths, p)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	a, err := NewRestAdapter(ConnectionCtxt{URL: srv.URL + "/magda/", Host: "ignored:1"})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := a.Get(context.Background(), BasePath(a, RegistryAPI)+"/records", log.NewNop()); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if path != "/magda/api/v0/registry/records" {
+		t.Fatalf("unexpected request path '%s'", path)
+	}
+
+	for _, u := range []string{"magda.example.com", "ftp://magda.example.com", "https://", "http://h/?q=1"} {
+		if _, err := NewRestAdapter(ConnectionCtxt{URL: u}); err == nil {
+			t.Errorf("expected error for URL '%s'", u)
+		}
+	}
+}
@@ -39,12 +39,26 @@ func LoadPayloadFromFile(fileName string, isYAML bool) (Payload, error) {
 
 func LoadPayloadFromBytes(data []byte, isYAML bool) (pyld Payload, err error) {
 	if isYAML {
-		obj := make(map[interface{}]interface{})
-		if err = yaml.Unmarshal(data, &obj); err != nil {
+		var v interface{}
+		if err = yaml.Unmarshal(data, &v); err != nil {
 			return
 		}
-		if data, err = yamlToJSON(obj); err != nil {
-			return
+		if arr, ok := v.([]interface{}); ok {
+			// top level arrays, e.g. for patches
+			if data, err = json.Marshal(cleanYamlValue(arr)); err != nil {
+				return
+			}
+		} else {
+			obj, ok := v.(map[interface{}]interface{})
+			if v == nil {
+				obj = make(map[interface{}]interface{})
+			} else if !ok {
+				err = errors.New("expected a YAML object or array")
+				return
+			}
+			if data, err = yamlToJSON(obj); err != nil {
+				return
+			}
 		}
 	}
 	pyld = &payload{body: data}
@@ -85,6 +99,21 @@ func cleanYaml(in map[interface{}]interface{}) map[string]interface{} {
 	return output
 }
 
+// cleanYamlValue converts all maps in 'v' into ones with string keys
+func cleanYamlValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		return cleanYaml(x)
+	case []interface{}:
+		for i, item := range x {
+			x[i] = cleanYamlValue(item)
+		}
+		return x
+	default:
+		return v
+	}
+}
+
 func ReplyPrinter(pld Payload, useYAML bool) (err error) {
 	var f interface{}
 	if err = pld.AsType(&f); err != nil {
@@ -97,3 +97,16 @@ a:
 		}
 	}
 }
+
+func TestYamlTopLevelArray(t *testing.T) {
+	pld, err := LoadPayloadFromBytes([]byte("- op: add\n  path: /a\n  value:\n    b: [1, {c: 2}]\n"), true)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if s := string(pld.AsBytes()); s != `[{"op":"add","path":"/a","value":{"b":[1,{"c":2}]}}]` {
+		t.Errorf("unexpected JSON %s", s)
+	}
+	if _, err := LoadPayloadFromBytes([]byte("just a string"), true); err == nil {
+		t.Errorf("expected error for YAML scalar")
+	}
+}
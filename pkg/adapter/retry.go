@@ -0,0 +1,126 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how often, and how patiently, a failed call to Magda
+// is repeated. The zero value disables retries.
+type RetryPolicy struct {
+	MaxAttempts int           // total number of attempts, including the first one
+	BaseDelay   time.Duration // delay before the first retry, doubled for every further one
+	MaxDelay    time.Duration // upper bound for any single delay (0 means unbounded)
+	Jitter      float64       // fraction [0..1] of each delay which is randomised
+	// Also replay non-idempotent requests (POST, PATCH). They may have
+	// already been applied by the registry when the connection failed.
+	RetryNonIdempotent bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// canRetry returns true if 'method' may be replayed at all under this policy
+func (p *RetryPolicy) canRetry(method string) bool {
+	if p.MaxAttempts <= 1 {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return p.RetryNonIdempotent
+	}
+}
+
+// backoff returns the delay before attempt number 'attempt' (starting with 1 for the
+// first retry) - exponentially growing, capped by MaxDelay and randomised by Jitter.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		j := math.Min(p.Jitter, 1)
+		d = d - d*j*rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// retryAfter returns the delay requested by the server through a 'Retry-After'
+// header, which is either given in seconds or as an HTTP date.
+func (p *RetryPolicy) retryAfter(resp *http.Response) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(h); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d, true
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError returns true for transient transport errors - timeouts,
+// refused or reset connections and responses cut short - but not when the
+// caller's context is done. Others, like certificate errors, unknown hosts or
+// malformed URLs, won't go away by trying again.
+func isRetryableError(ctxt context.Context, err error) bool {
+	if ctxt.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sleep waits for 'd' or until 'ctxt' is done, whatever comes first
+func sleep(ctxt context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctxt.Done():
+		return ctxt.Err()
+	case <-t.C:
+		return nil
+	}
+}
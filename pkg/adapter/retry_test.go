@@ -0,0 +1,137 @@
+package adapter
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+func testServer(t *testing.T, statusCodes ...int) (*httptest.Server, *int) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusOK
+		if calls < len(statusCodes) {
+			code = statusCodes[calls]
+		}
+		calls++
+		if code == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(`{"id":"foo"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func testAdapter(srv *httptest.Server, policy RetryPolicy) Adapter {
+	return RestAdapter(ConnectionCtxt{
+		Host:  strings.TrimPrefix(srv.URL, "http://"),
+		Retry: policy,
+	})
+}
+
+func TestRetryOnGatewayError(t *testing.T) {
+	srv, calls := testServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	a := testAdapter(srv, policy)
+	pld, err := a.Get(context.Background(), "/foo", log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("expected 3 calls, but got %d", *calls)
+	}
+	if obj, _ := pld.AsObject(); obj["id"] != "foo" {
+		t.Fatalf("unexpected payload '%s'", pld.AsBytes())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv, calls := testServer(t, 502, 502, 502, 502)
+	a := testAdapter(srv, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	_, err := a.Get(context.Background(), "/foo", log.NewNop())
+	if e, ok := err.(*MagdaError); !ok || e.StatusCode != 502 {
+		t.Fatalf("expected MagdaError with status 502, but got %v", err)
+	}
+	if *calls != 2 {
+		t.Fatalf("expected 2 calls, but got %d", *calls)
+	}
+}
+
+func TestNoRetryOnPost(t *testing.T) {
+	srv, calls := testServer(t, 503)
+	a := testAdapter(srv, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	if _, err := a.Post(context.Background(), "/foo", strings.NewReader("{}"), log.NewNop()); err == nil {
+		t.Fatalf("expected error")
+	}
+	if *calls != 1 {
+		t.Fatalf("expected 1 call, but got %d", *calls)
+	}
+
+	srv, calls = testServer(t, 503)
+	a = testAdapter(srv, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryNonIdempotent: true})
+	if _, err := a.Post(context.Background(), "/foo", strings.NewReader("{}"), log.NewNop()); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if *calls != 2 {
+		t.Fatalf("expected 2 calls, but got %d", *calls)
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	opErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://magda", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+	}
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{opErr(syscall.ECONNREFUSED), true},
+		{opErr(syscall.ECONNRESET), true},
+		{&url.Error{Op: "Get", URL: "http://magda", Err: io.ErrUnexpectedEOF}, true},
+		{&url.Error{Op: "Get", URL: "http://magda", Err: context.DeadlineExceeded}, true},
+		{&net.DNSError{Err: "i/o timeout", Name: "magda", IsTimeout: true}, true},
+		{&url.Error{Op: "Get", URL: "http://magda", Err: context.Canceled}, false},
+		{&url.Error{Op: "Get", URL: "https://magda", Err: x509.UnknownAuthorityError{}}, false},
+		{opErr(&net.DNSError{Err: "no such host", Name: "magda", IsNotFound: true}), false},
+		{&url.Error{Op: "Get", URL: "magda", Err: errors.New("unsupported protocol scheme \"\"")}, false},
+	}
+	for _, c := range cases {
+		if r := isRetryableError(context.Background(), c.err); r != c.retryable {
+			t.Errorf("%v: expected retryable to be %v", c.err, c.retryable)
+		}
+	}
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isRetryableError(ctxt, opErr(syscall.ECONNREFUSED)) {
+		t.Errorf("expected no retry after the context is done")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for i, exp := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if d := p.backoff(i + 1); d != exp*time.Millisecond {
+			t.Errorf("attempt %d: expected %v, but got %v", i+1, exp*time.Millisecond, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if d := p.backoff(2); d < 100*time.Millisecond || d > 200*time.Millisecond {
+			t.Errorf("jittered delay %v out of range", d)
+		}
+	}
+}
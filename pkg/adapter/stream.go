@@ -0,0 +1,182 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "go.uber.org/zap"
+)
+
+type streamPayload struct {
+	body        io.ReadCloser
+	contentType string
+	dec         *json.Decoder
+}
+
+func (p *streamPayload) Read(b []byte) (int, error) {
+	return p.body.Read(b)
+}
+
+func (p *streamPayload) Close() error {
+	return p.body.Close()
+}
+
+func (p *streamPayload) ContentType() string {
+	return p.contentType
+}
+
+func (p *streamPayload) Decoder() *json.Decoder {
+	if p.dec == nil {
+		p.dec = json.NewDecoder(p.body)
+		p.dec.UseNumber()
+	}
+	return p.dec
+}
+
+// GetStream calls 'path' on 'adpt' and returns the body as a stream. Adapters not
+// implementing StreamingAdapter are served from a buffered Payload.
+func GetStream(ctxt context.Context, adpt Adapter, path string, logger *log.Logger) (StreamPayload, error) {
+	if sa, ok := adpt.(StreamingAdapter); ok {
+		return sa.GetStream(ctxt, path, logger)
+	}
+	pld, err := adpt.Get(ctxt, path, logger)
+	if err != nil {
+		return nil, err
+	}
+	contentType := ""
+	if p, ok := pld.(*payload); ok {
+		contentType = p.contentType
+	}
+	return &streamPayload{body: ioutil.NopCloser(bytes.NewReader(pld.AsBytes())), contentType: contentType}, nil
+}
+
+// StreamObject walks the JSON object next in 'dec' and calls 'onField' for
+// each of its fields. 'onField' needs to consume the field's value, e.g. with
+// 'dec.Decode', StreamArray, or SkipValue.
+func StreamObject(dec *json.Decoder, onField func(name string, dec *json.Decoder) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected field name, but got '%v'", t)
+		}
+		if err := onField(name, dec); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// StreamArray walks the JSON array next in 'dec' and calls 'onElement' for
+// each of its elements, which needs to consume it, e.g. with 'dec.Decode'.
+func StreamArray(dec *json.Decoder, onElement func(dec *json.Decoder) error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := onElement(dec); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+// SkipValue consumes the next value in 'dec'
+func SkipValue(dec *json.Decoder) error {
+	var v json.RawMessage
+	return dec.Decode(&v)
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("expected '%v', but got '%v'", d, t)
+	}
+	return nil
+}
+
+// StreamPrinter is the streaming version of ReplyPrinter. JSON is printed
+// while being read, YAML needs to be buffered.
+func StreamPrinter(sp StreamPayload, useYAML bool) error {
+	defer sp.Close()
+	if useYAML {
+		body, err := ioutil.ReadAll(sp)
+		if err != nil {
+			return err
+		}
+		return ReplyPrinter(&payload{body: body, contentType: sp.ContentType()}, true)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := writeIndented(sp.Decoder(), w, 0); err != nil {
+		return err
+	}
+	w.WriteString("\n")
+	return w.Flush()
+}
+
+// writeIndented copies the next value from 'dec' to 'w' token by token, using
+// the same indentation as ReplyPrinter.
+func writeIndented(dec *json.Decoder, w *bufio.Writer, depth int) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	d, ok := t.(json.Delim)
+	if !ok {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	closing := "]"
+	if d == '{' {
+		closing = "}"
+	}
+	w.WriteString(d.String())
+	if dec.More() {
+		indent := strings.Repeat("  ", depth+1)
+		for first := true; dec.More(); first = false {
+			if !first {
+				w.WriteString(",")
+			}
+			w.WriteString("\n" + indent)
+			if d == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				b, _ := json.Marshal(k)
+				w.Write(b)
+				w.WriteString(": ")
+			}
+			if err := writeIndented(dec, w, depth+1); err != nil {
+				return err
+			}
+		}
+		w.WriteString("\n" + strings.Repeat("  ", depth))
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	w.WriteString(closing)
+	return nil
+}
@@ -0,0 +1,68 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStreamObject(t *testing.T) {
+	j := `{"hasMore": true, "records": [{"id": "a"}, {"id": "b"}], "extra": {"x": [1, 2]}, "nextPageToken": "42"}`
+	dec := json.NewDecoder(strings.NewReader(j))
+	ids := []string{}
+	var token string
+	err := StreamObject(dec, func(name string, dec *json.Decoder) error {
+		switch name {
+		case "records":
+			return StreamArray(dec, func(dec *json.Decoder) error {
+				var r struct {
+					ID string `json:"id"`
+				}
+				if err := dec.Decode(&r); err != nil {
+					return err
+				}
+				ids = append(ids, r.ID)
+				return nil
+			})
+		case "nextPageToken":
+			return dec.Decode(&token)
+		default:
+			return SkipValue(dec)
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if strings.Join(ids, ",") != "a,b" || token != "42" {
+		t.Fatalf("unexpected result %v - '%s'", ids, token)
+	}
+}
+
+func TestStreamArrayNotAnArray(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"a": 1}`))
+	if err := StreamArray(dec, func(dec *json.Decoder) error { return SkipValue(dec) }); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestWriteIndented(t *testing.T) {
+	j := `{"b": [1, 2.50, {"c": null}], "a": {}, "e": [], "s": "x\"z"}`
+	dec := json.NewDecoder(strings.NewReader(j))
+	dec.UseNumber()
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if err := writeIndented(dec, w, 0); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	w.Flush()
+
+	var exp bytes.Buffer
+	if err := json.Indent(&exp, []byte(`{"b":[1,2.50,{"c":null}],"a":{},"e":[],"s":"x\"z"}`), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != exp.String() {
+		t.Fatalf("expected\n%s\nbut got\n%s", exp.String(), out.String())
+	}
+}
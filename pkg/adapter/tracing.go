@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	log "go.uber.org/zap"
+)
+
+const tracerName = "github.com/maxott/magda-cli/pkg/adapter"
+
+// Attributes recorded on every span
+var (
+	MethodKey     = attribute.Key("http.method")
+	StatusCodeKey = attribute.Key("http.status_code")
+	PathKey       = attribute.Key("magda.path")
+	TenantKey     = attribute.Key("magda.tenant_id")
+)
+
+// WithTracing creates an OpenTelemetry span for every call, using 'tp' or the
+// global tracer provider if nil. The span is a child of any span found in the
+// context passed in, and is sent on to Magda in the W3C 'traceparent' header.
+func WithTracing(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		if tp == nil {
+			tp = otel.GetTracerProvider()
+		}
+		o.middlewares = append(o.middlewares, Intercept(traceInterceptor(tp.Tracer(tracerName))))
+		o.transports = append(o.transports, traceTransport)
+	}
+}
+
+func traceInterceptor(tracer trace.Tracer) Interceptor {
+	return func(ctxt context.Context, call *Call, logger *log.Logger, next func(ctxt context.Context) error) error {
+		ctxt, span := tracer.Start(ctxt, "Magda "+call.Method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(MethodKey.String(call.Method), PathKey.String(call.Path)),
+		)
+		defer span.End()
+
+		err := next(ctxt)
+		if err != nil {
+			var merr *MagdaError
+			if errors.As(err, &merr) {
+				span.SetAttributes(StatusCodeKey.Int(merr.StatusCode))
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+// traceTransport injects the 'traceparent' header and records what is only
+// known at the HTTP level on the current span. With retries, the last attempt
+// wins.
+func traceTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		ctxt := req.Context()
+		span := trace.SpanFromContext(ctxt)
+		if !span.SpanContext().IsValid() {
+			return next.RoundTrip(req)
+		}
+		req = req.Clone(ctxt)
+		propagation.TraceContext{}.Inject(ctxt, propagation.HeaderCarrier(req.Header))
+		if tenant := req.Header.Get("X-Magda-Tenant-Id"); tenant != "" {
+			span.SetAttributes(TenantKey.String(tenant))
+		}
+		resp, err := next.RoundTrip(req)
+		if resp != nil {
+			span.SetAttributes(StatusCodeKey.Int(resp.StatusCode))
+		}
+		return resp, err
+	})
+}
@@ -0,0 +1,60 @@
+package adapter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	log "go.uber.org/zap"
+)
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	a := RestAdapter(ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://"), TenantID: "7"}, WithTracing(tp))
+
+	ctxt, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if _, err := a.Get(ctxt, "/records", log.NewNop()); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	a.Delete(ctxt, "/missing", log.NewNop())
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, but got %d", len(spans))
+	}
+	get, del := spans[0], spans[1]
+	if get.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("span isn't a child of the context's span")
+	}
+	attrs := map[string]string{}
+	for _, kv := range get.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.method"] != "GET" || attrs["magda.path"] != "/records" ||
+		attrs["http.status_code"] != "200" || attrs["magda.tenant_id"] != "7" {
+		t.Fatalf("unexpected span attributes %v", attrs)
+	}
+	if !strings.Contains(traceparent, del.SpanContext().TraceID().String()) {
+		t.Fatalf("traceparent header '%s' doesn't match trace", traceparent)
+	}
+	if del.Status().Code.String() != "Error" {
+		t.Fatalf("expected error status for 404, but got %v", del.Status())
+	}
+}
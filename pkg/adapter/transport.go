@@ -0,0 +1,98 @@
+package adapter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultTimeout     = 10 * time.Second
+	DefaultDialTimeout = 30 * time.Second
+)
+
+// TransportCtxt declares how to reach the Magda server on the network level
+type TransportCtxt struct {
+	Timeout            time.Duration // time limit for a request including its response body, or only its headers when streamed (default: DefaultTimeout)
+	DialTimeout        time.Duration // time limit for establishing a connection (default: DefaultDialTimeout)
+	ProxyURL           string        // if not set, HTTP_PROXY, HTTPS_PROXY & NO_PROXY are honored
+	CAFile             string        // PEM file with additional root CAs
+	ClientCertFile     string        // PEM file with client certificate for mTLS
+	ClientKeyFile      string        // PEM file with key for ClientCertFile
+	InsecureSkipVerify bool          // don't verify the server's certificate - for dev clusters only!
+}
+
+// newHTTPClient creates a client to be shared by all requests of an adapter
+// so that keep-alive connections get reused.
+func newHTTPClient(tc *TransportCtxt) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialTimeout := tc.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	if tc.ProxyURL != "" {
+		proxy, err := url.Parse(tc.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("illegal proxy URL '%s' - %s", tc.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig, err := newTLSConfig(tc)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	// no 'http.Client.Timeout', as that would also cut off long streamed bodies.
+	// Non-streamed calls get a deadline for the whole request instead.
+	transport.ResponseHeaderTimeout = tc.timeout()
+	return &http.Client{Transport: transport}, nil
+}
+
+func (tc *TransportCtxt) timeout() time.Duration {
+	if tc.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return tc.Timeout
+}
+
+func newTLSConfig(tc *TransportCtxt) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: tc.InsecureSkipVerify}
+	if tc.CAFile != "" {
+		pem, err := ioutil.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file '%s' - %s", tc.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", tc.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if tc.ClientCertFile != "" || tc.ClientKeyFile != "" {
+		if tc.ClientCertFile == "" || tc.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key need to be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(tc.ClientCertFile, tc.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate - %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
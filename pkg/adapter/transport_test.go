@@ -0,0 +1,86 @@
+package adapter
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+func TestCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	a := RestAdapter(ConnectionCtxt{Host: host, UseTLS: true})
+	if _, err := a.Get(context.Background(), "/", log.NewNop()); err == nil {
+		t.Fatalf("expected certificate error")
+	}
+
+	a, err := NewRestAdapter(ConnectionCtxt{Host: host, UseTLS: true, Transport: TransportCtxt{CAFile: caFile}})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := a.Get(context.Background(), "/", log.NewNop()); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+}
+
+func TestIllegalTransport(t *testing.T) {
+	if _, err := NewRestAdapter(ConnectionCtxt{Transport: TransportCtxt{CAFile: "/does/not/exist"}}); err == nil {
+		t.Fatalf("expected error for missing CA file")
+	}
+	if _, err := NewRestAdapter(ConnectionCtxt{Transport: TransportCtxt{ClientCertFile: "cert.pem"}}); err == nil {
+		t.Fatalf("expected error for missing client key")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[`))
+		w.(http.Flusher).Flush()
+		// the body takes longer than the timeout
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`1]`))
+	}))
+	defer srv.Close()
+	a, err := NewRestAdapter(ConnectionCtxt{URL: srv.URL, Transport: TransportCtxt{Timeout: 100 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	ctxt := context.Background()
+
+	if _, err := a.Get(ctxt, "/body", log.NewNop()); err == nil {
+		t.Fatalf("expected timeout reading the body")
+	}
+	if _, err := GetStream(ctxt, a, "/slow", log.NewNop()); err == nil {
+		t.Fatalf("expected timeout waiting for the headers")
+	}
+	sp, err := GetStream(ctxt, a, "/body", log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	defer sp.Close()
+	if b, err := ioutil.ReadAll(sp); err != nil || string(b) != "[1]" {
+		t.Fatalf("unexpected stream %q - %v", b, err)
+	}
+}
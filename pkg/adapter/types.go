@@ -2,6 +2,7 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 
 	log "go.uber.org/zap"
@@ -24,3 +25,18 @@ type Payload interface {
 	AsArray() ([]interface{}, error)
 	AsBytes() []byte
 }
+
+// StreamPayload gives access to a response body without loading it into
+// memory first. It needs to be closed after use.
+type StreamPayload interface {
+	io.ReadCloser
+	ContentType() string
+	// Decoder returns a JSON decoder reading from the body. Numbers are decoded as json.Number
+	Decoder() *json.Decoder
+}
+
+// StreamingAdapter is implemented by adapters which can hand out the body of a
+// response as a stream. Use GetStream to fall back to a buffered Payload for all others.
+type StreamingAdapter interface {
+	GetStream(ctxt context.Context, path string, logger *log.Logger) (StreamPayload, error)
+}
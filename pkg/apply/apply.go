@@ -0,0 +1,288 @@
+// Declarative management of aspect definitions and records
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/record"
+	"github.com/maxott/magda-cli/pkg/schema"
+	log "go.uber.org/zap"
+)
+
+/**** PLAN ****/
+
+type Kind string
+
+const (
+	AspectKind Kind = "aspect"
+	RecordKind Kind = "record"
+)
+
+type Op string
+
+const (
+	Create   Op = "create"
+	Update   Op = "update"
+	NoChange Op = "no-op"
+)
+
+// Step brings a single aspect definition or record in line with the manifest
+type Step struct {
+	Kind Kind
+	ID   string
+	Op   Op
+	// Changes lists the differences to the remote state, with paths relative
+	// to the aspect definition ('/name', '/jsonSchema/...') or the record
+	// ('/name', '/sourceTag', '/aspects/<aspect>/...'). Empty for Create.
+	Changes []record.Change
+	// SchemaChanges classifies the changes to the JSON schema of an aspect
+	// definition for Update, see schema.CompareSchemas
+	SchemaChanges []schema.SchemaChange
+
+	aspect  *schema.CreateRequest
+	record  *record.CreateRequest       // for Create, or Update of name, source tag and missing aspects
+	patches map[string][]record.PatchOp // for Update of existing aspects
+	aspects record.Aspects              // all aspects of a record as declared in the manifest
+}
+
+// Breaking returns the changes to the schema of an aspect definition which
+// existing data may no longer match
+func (s *Step) Breaking() []schema.SchemaChange {
+	return schema.Breaking(s.SchemaChanges)
+}
+
+type Plan struct {
+	Steps []Step
+}
+
+// Count returns the number of steps performing 'op'
+func (p *Plan) Count(op Op) int {
+	n := 0
+	for _, s := range p.Steps {
+		if s.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// Breaking returns the number of breaking schema changes of all steps
+func (p *Plan) Breaking() int {
+	n := 0
+	for i := range p.Steps {
+		n += len(p.Steps[i].Breaking())
+	}
+	return n
+}
+
+// MakePlan compares every aspect definition and record in 'm' with the
+// registry. Aspects of a record which are not in the manifest are left alone.
+func MakePlan(ctxt context.Context, m *Manifest, adpt *adapter.Adapter, logger *log.Logger) (*Plan, error) {
+	p := &Plan{}
+	for i := range m.Aspects {
+		s, err := planAspect(ctxt, &m.Aspects[i], adpt, logger)
+		if err != nil {
+			return nil, err
+		}
+		p.Steps = append(p.Steps, s)
+	}
+	for i := range m.Records {
+		s, err := planRecord(ctxt, &m.Records[i], adpt, logger)
+		if err != nil {
+			return nil, err
+		}
+		p.Steps = append(p.Steps, s)
+	}
+	return p, nil
+}
+
+func planAspect(ctxt context.Context, spec *AspectSpec, adpt *adapter.Adapter, logger *log.Logger) (Step, error) {
+	s := Step{Kind: AspectKind, ID: spec.ID, Op: Create,
+		aspect: &schema.CreateRequest{Id: spec.ID, Name: spec.Name, Schema: spec.JSONSchema}}
+	remote, err := schema.Read(ctxt, &schema.ReadRequest{Id: spec.ID}, adpt, logger)
+	if errors.Is(err, adapter.ErrNotFound) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	from := map[string]interface{}{"name": remote.Name, "jsonSchema": remote.JSONSchema}
+	to := map[string]interface{}{"name": spec.Name, "jsonSchema": spec.JSONSchema}
+	s.Changes = record.Diff(from, to)
+	s.SchemaChanges = schema.CompareSchemas(remote.JSONSchema, spec.JSONSchema)
+	s.Op = opFor(s.Changes)
+	return s, nil
+}
+
+func planRecord(ctxt context.Context, spec *RecordSpec, adpt *adapter.Adapter, logger *log.Logger) (Step, error) {
+	aspects := record.Aspects{}
+	for name, a := range spec.Aspects {
+		aspects[name] = a
+	}
+	name := spec.Name
+	if name == "" {
+		name = spec.ID
+	}
+	s := Step{Kind: RecordKind, ID: spec.ID, Op: Create, aspects: aspects,
+		record: &record.CreateRequest{Id: spec.ID, Name: name, SourceTag: spec.SourceTag, Aspects: aspects}}
+
+	names := make([]string, 0, len(spec.Aspects))
+	for n := range spec.Aspects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	remote, err := record.Read(ctxt, &record.ReadRequest{Id: spec.ID, OptionalAspects: strings.Join(names, ",")}, adpt, logger)
+	if errors.Is(err, adapter.ErrNotFound) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+
+	update := &record.CreateRequest{Id: spec.ID, Name: remote.Name, SourceTag: remote.SourceTag, Aspects: record.Aspects{}}
+	needsUpdate := false
+	if spec.Name != "" && spec.Name != remote.Name {
+		s.Changes = append(s.Changes, record.Change{Op: "replace", Path: "/name", OldValue: remote.Name, NewValue: spec.Name})
+		update.Name = spec.Name
+		needsUpdate = true
+	}
+	if spec.SourceTag != "" && spec.SourceTag != remote.SourceTag {
+		s.Changes = append(s.Changes, record.Change{Op: "replace", Path: "/sourceTag", OldValue: remote.SourceTag, NewValue: spec.SourceTag})
+		update.SourceTag = spec.SourceTag
+		needsUpdate = true
+	}
+	s.patches = map[string][]record.PatchOp{}
+	for _, n := range names {
+		path := "/aspects/" + strings.ReplaceAll(strings.ReplaceAll(n, "~", "~0"), "/", "~1")
+		ra, ok := remote.Aspects[n]
+		if !ok {
+			s.Changes = append(s.Changes, record.Change{Op: "add", Path: path, NewValue: spec.Aspects[n]})
+			update.Aspects[n] = spec.Aspects[n]
+			needsUpdate = true
+			continue
+		}
+		changes := record.Diff(ra, map[string]interface{}(spec.Aspects[n]))
+		if len(changes) == 0 {
+			continue
+		}
+		ops := make([]record.PatchOp, len(changes))
+		for i := range changes {
+			ops[i] = changes[i].PatchOp()
+			changes[i].Path = path + changes[i].Path
+		}
+		s.Changes = append(s.Changes, changes...)
+		s.patches[n] = ops
+	}
+	s.record = nil
+	if needsUpdate {
+		s.record = update
+	}
+	s.Op = opFor(s.Changes)
+	return s, nil
+}
+
+func opFor(changes []record.Change) Op {
+	if len(changes) == 0 {
+		return NoChange
+	}
+	return Update
+}
+
+/**** VALIDATE ****/
+
+// Validate checks the aspects of all records to be created or updated against
+// their schemas. Aspect definitions in the plan are checked against the schema
+// declared in the manifest, all others through 'v'. The first violation is
+// returned, wrapping a *schema.ValidationError.
+func (p *Plan) Validate(ctxt context.Context, v *schema.Validator) error {
+	declared := map[string]*schema.Schema{}
+	for _, s := range p.Steps {
+		if s.Kind != AspectKind {
+			continue
+		}
+		declared[s.ID] = nil
+		if len(s.aspect.Schema) == 0 {
+			continue
+		}
+		c, err := schema.Compile(s.ID, s.aspect.Schema)
+		if err != nil {
+			return err
+		}
+		declared[s.ID] = c
+	}
+	for _, s := range p.Steps {
+		if s.Kind != RecordKind || s.Op == NoChange {
+			continue
+		}
+		names := make([]string, 0, len(s.aspects))
+		for n := range s.aspects {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			var err error
+			if c, ok := declared[n]; !ok {
+				err = v.Validate(ctxt, n, s.aspects[n])
+			} else if c != nil {
+				err = c.Validate(s.aspects[n])
+			}
+			if err != nil {
+				return fmt.Errorf("record '%s' - %w", s.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+/**** APPLY ****/
+
+// Apply executes all steps of the plan, aspect definitions first. It stops at
+// the first error. 'onStep' is called after every step changing anything.
+func (p *Plan) Apply(ctxt context.Context, adpt *adapter.Adapter, logger *log.Logger, onStep func(s *Step)) error {
+	for i := range p.Steps {
+		s := &p.Steps[i]
+		if s.Op == NoChange {
+			continue
+		}
+		if err := s.apply(ctxt, adpt, logger); err != nil {
+			return err
+		}
+		if onStep != nil {
+			onStep(s)
+		}
+	}
+	return nil
+}
+
+func (s *Step) apply(ctxt context.Context, adpt *adapter.Adapter, logger *log.Logger) error {
+	var err error
+	switch {
+	case s.Kind == AspectKind && s.Op == Create:
+		_, err = schema.CreateRaw(ctxt, s.aspect, adpt, logger)
+	case s.Kind == AspectKind:
+		_, err = schema.UpdateRaw(ctxt, s.aspect, adpt, logger)
+	case s.Op == Create:
+		_, err = record.CreateRaw(ctxt, s.record, adpt, logger)
+	default:
+		if s.record != nil {
+			if _, err = record.UpdateRaw(ctxt, s.record, adpt, logger); err != nil {
+				return err
+			}
+		}
+		names := make([]string, 0, len(s.patches))
+		for n := range s.patches {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			req := &record.PatchAspectRequest{Id: s.ID, Aspect: n, Patch: s.patches[n]}
+			if _, err = record.PatchAspectRaw(ctxt, req, adpt, logger); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
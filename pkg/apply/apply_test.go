@@ -0,0 +1,180 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	"github.com/maxott/magda-cli/pkg/record"
+	"github.com/maxott/magda-cli/pkg/schema"
+	log "go.uber.org/zap"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const manifest = `
+aspects:
+  - id: order
+    schemaFile: order.json
+records:
+  - id: o1
+    name: First
+    aspectFiles:
+      order: o1.json
+    aspects:
+      tags: {list: [a]}
+`
+
+func makePlan(t *testing.T, dir string, adpt *adapter.Adapter) *Plan {
+	m, err := LoadManifests(dir)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	p, err := MakePlan(context.Background(), m, adpt, log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	return p
+}
+
+func TestApply(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	dir := writeFiles(t, map[string]string{
+		"manifest.yaml": manifest,
+		"order.json":    `{"type": "object"}`,
+		"o1.json":       `{"status": "pending", "amount": 5}`,
+	})
+
+	p := makePlan(t, dir, adpt)
+	if len(p.Steps) != 2 || p.Count(Create) != 2 || p.Steps[0].Kind != AspectKind || p.Steps[1].Kind != RecordKind {
+		t.Fatalf("unexpected plan %+v", p)
+	}
+	if err := p.Apply(ctxt, adpt, logger, nil); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if p = makePlan(t, dir, adpt); p.Count(NoChange) != 2 {
+		t.Fatalf("expected no changes, got %+v", p)
+	}
+
+	// changes to the remote record, which should be reverted
+	patch := &record.PatchAspectRequest{Id: "o1", Aspect: "order", Patch: []record.PatchOp{
+		record.PatchReplaceOp("/status", "done"),
+		record.PatchAddOp("/extra", true),
+	}}
+	if _, err := record.PatchAspectRaw(ctxt, patch, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := record.UpdateRaw(ctxt, &record.UpdateRequest{Id: "o1", Name: "Renamed", Aspects: record.Aspects{"other": {"x": 1}}}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := record.DeleteRaw(ctxt, &record.DeleteRequest{Id: "o1", AspectName: "tags"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "order.json"), []byte(`{"type": "object", "title": "Order"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p = makePlan(t, dir, adpt)
+	if p.Count(Update) != 2 {
+		t.Fatalf("unexpected plan %+v", p)
+	}
+	paths := []string{}
+	for _, c := range p.Steps[1].Changes {
+		paths = append(paths, c.Op+" "+c.Path)
+	}
+	if s := strings.Join(paths, ", "); s != "replace /name, remove /aspects/order/extra, replace /aspects/order/status, add /aspects/tags" {
+		t.Errorf("unexpected changes %s", s)
+	}
+	if c := p.Steps[0].Changes; len(c) != 1 || c[0].Path != "/jsonSchema/title" {
+		t.Errorf("unexpected schema changes %+v", c)
+	}
+	if err := p.Apply(ctxt, adpt, logger, nil); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if p = makePlan(t, dir, adpt); p.Count(NoChange) != 2 {
+		t.Fatalf("expected no changes, got %+v", p)
+	}
+	// aspects not in the manifest are left alone
+	r, err := record.Read(ctxt, &record.ReadRequest{Id: "o1", OptionalAspects: "other"}, adpt, logger)
+	if err != nil || r.Aspects["other"] == nil {
+		t.Errorf("unexpected record %+v, error %v", r, err)
+	}
+}
+
+func TestBreakingAndValidate(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	dir := writeFiles(t, map[string]string{
+		"manifest.yaml": manifest,
+		"order.json":    `{"type": "object"}`,
+		"o1.json":       `{"status": "pending", "amount": 5}`,
+	})
+	if err := makePlan(t, dir, adpt).Apply(ctxt, adpt, logger, nil); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	// the record is checked against the changed schema in the manifest
+	required := `{"type": "object", "required": ["status", "customer"]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "order.json"), []byte(required), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := makePlan(t, dir, adpt)
+	if n := p.Breaking(); n != 2 || len(p.Steps[0].Breaking()) != 2 {
+		t.Fatalf("expected 2 breaking changes, but got %d - %+v", n, p.Steps[0].SchemaChanges)
+	}
+	if err := p.Validate(ctxt, schema.NewValidator(nil, adpt, logger)); err != nil {
+		t.Fatalf("unchanged records shouldn't be checked - %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "o1.json"), []byte(`{"status": "done"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p = makePlan(t, dir, adpt)
+	var verr *schema.ValidationError
+	if err := p.Validate(ctxt, schema.NewValidator(nil, adpt, logger)); !errors.As(err, &verr) || verr.Aspect != "order" {
+		t.Fatalf("expected validation error for 'order', but got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "o1.json"), []byte(`{"status": "done", "customer": "c"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := makePlan(t, dir, adpt).Validate(ctxt, schema.NewValidator(nil, adpt, logger)); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+}
+
+func TestLoadManifests(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yaml":     "aspects:\n  - id: x\n    jsonSchema: {}\n",
+		"b.yml":      "aspects:\n  - id: x\n    jsonSchema: {}\n",
+		"other.json": "{}",
+	})
+	if _, err := LoadManifests(dir); err == nil || !strings.Contains(err.Error(), "aspect 'x' is declared in") {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+	m, err := LoadManifests(filepath.Join(dir, "a.yaml"))
+	if err != nil || len(m.Aspects) != 1 || m.Aspects[0].Name != "x" {
+		t.Errorf("unexpected manifest %+v, error %v", m, err)
+	}
+	dir = writeFiles(t, map[string]string{"m.yaml": "aspects:\n  - id: x\n"})
+	if _, err := LoadManifests(dir); err == nil || !strings.Contains(err.Error(), "missing a 'jsonSchema'") {
+		t.Errorf("expected missing schema error, got %v", err)
+	}
+	if _, err := LoadManifests(t.TempDir()); err == nil {
+		t.Errorf("expected error for empty directory")
+	}
+}
@@ -0,0 +1,177 @@
+package apply
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+)
+
+// Manifest declares the aspect definitions and records which should exist in
+// the registry. Schemas and aspects can be given inline or as files, relative
+// to the manifest.
+//
+//	aspects:
+//	  - id: cse-order
+//	    schemaFile: schema/order.json
+//	records:
+//	  - id: ffdi
+//	    name: Forest Fire Danger Index
+//	    aspectFiles:
+//	      cse-service: record/ffdi_service.json
+//	    aspects:
+//	      tags: {list: [fire]}
+type Manifest struct {
+	Aspects []AspectSpec `json:"aspects"`
+	Records []RecordSpec `json:"records"`
+}
+
+type AspectSpec struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"` // defaults to the ID
+	JSONSchema map[string]interface{} `json:"jsonSchema"`
+	SchemaFile string                 `json:"schemaFile"`
+	source     string
+}
+
+type RecordSpec struct {
+	ID          string                            `json:"id"`
+	Name        string                            `json:"name"`      // defaults to the ID for new records, left alone otherwise
+	SourceTag   string                            `json:"sourceTag"` // left alone if empty
+	Aspects     map[string]map[string]interface{} `json:"aspects"`
+	AspectFiles map[string]string                 `json:"aspectFiles"`
+	source      string
+}
+
+// LoadManifest reads a manifest from a JSON or YAML file and loads all the
+// files it refers to.
+func LoadManifest(fileName string) (*Manifest, error) {
+	pld, err := adapter.LoadPayloadFromFile(fileName, isYAML(fileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := pld.AsType(m); err != nil {
+		return nil, fmt.Errorf("reading manifest '%s' - %s", fileName, err)
+	}
+	dir := filepath.Dir(fileName)
+	for i := range m.Aspects {
+		a := &m.Aspects[i]
+		a.source = fileName
+		if a.ID == "" {
+			return nil, fmt.Errorf("aspect #%d in '%s' is missing an 'id'", i+1, fileName)
+		}
+		if a.Name == "" {
+			a.Name = a.ID
+		}
+		if a.SchemaFile != "" {
+			if a.JSONSchema != nil {
+				return nil, fmt.Errorf("aspect '%s' in '%s' has both 'jsonSchema' and 'schemaFile'", a.ID, fileName)
+			}
+			if a.JSONSchema, err = loadObject(dir, a.SchemaFile); err != nil {
+				return nil, err
+			}
+		}
+		if a.JSONSchema == nil {
+			return nil, fmt.Errorf("aspect '%s' in '%s' is missing a 'jsonSchema' or 'schemaFile'", a.ID, fileName)
+		}
+	}
+	for i := range m.Records {
+		r := &m.Records[i]
+		r.source = fileName
+		if r.ID == "" {
+			return nil, fmt.Errorf("record #%d in '%s' is missing an 'id'", i+1, fileName)
+		}
+		if r.Aspects == nil {
+			r.Aspects = map[string]map[string]interface{}{}
+		}
+		for name, f := range r.AspectFiles {
+			if _, ok := r.Aspects[name]; ok {
+				return nil, fmt.Errorf("aspect '%s' of record '%s' in '%s' is given inline and as file", name, r.ID, fileName)
+			}
+			if r.Aspects[name], err = loadObject(dir, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// LoadManifests reads and merges the manifests in 'paths'. For a directory,
+// all '.yaml' and '.yml' files directly in it are read, so that JSON files
+// referred to can live alongside. Every aspect definition and record may
+// only be declared once.
+func LoadManifests(paths ...string) (*Manifest, error) {
+	res := &Manifest{}
+	aspects := map[string]string{}
+	records := map[string]string{}
+	for _, p := range paths {
+		files, err := manifestFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			m, err := LoadManifest(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range m.Aspects {
+				if prev, ok := aspects[a.ID]; ok {
+					return nil, fmt.Errorf("aspect '%s' is declared in '%s' and '%s'", a.ID, prev, a.source)
+				}
+				aspects[a.ID] = a.source
+				res.Aspects = append(res.Aspects, a)
+			}
+			for _, r := range m.Records {
+				if prev, ok := records[r.ID]; ok {
+					return nil, fmt.Errorf("record '%s' is declared in '%s' and '%s'", r.ID, prev, r.source)
+				}
+				records[r.ID] = r.source
+				res.Records = append(res.Records, r)
+			}
+		}
+	}
+	return res, nil
+}
+
+func manifestFiles(path string) ([]string, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		// not a directory
+		return []string{path}, nil
+	}
+	files := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && isYAML(e.Name()) && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no manifest ('.yaml' or '.yml' file) found in '%s'", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func loadObject(dir string, fileName string) (map[string]interface{}, error) {
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(dir, fileName)
+	}
+	pld, err := adapter.LoadPayloadFromFile(fileName, isYAML(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("loading '%s' - %s", fileName, err)
+	}
+	obj, err := pld.AsObject()
+	if err != nil {
+		return nil, fmt.Errorf("loading '%s' - %s", fileName, err)
+	}
+	return obj, nil
+}
+
+func isYAML(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
@@ -0,0 +1,347 @@
+// Bulk operations on many records at once
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/record"
+	"github.com/maxott/magda-cli/pkg/schema"
+	log "go.uber.org/zap"
+)
+
+/**** IMPORT ****/
+
+// Source calls 'onRecord' for every record to import. 'key' identifies the
+// record within the source, e.g. by line number, and is used for reporting
+// and checkpoints. Stop and return the error of 'onRecord' if it fails.
+type Source func(onRecord func(key string, r *record.CreateRequest) error) error
+
+type ImportRequest struct {
+	Source     Source
+	Update     bool        // use UpdateRaw, which creates or replaces records, instead of CreateRaw
+	Workers    int         // number of concurrent calls, defaults to 4
+	Checkpoint *Checkpoint // skip records already imported, and record successful ones
+	// OnResult is called for every record processed. Calls are never concurrent.
+	OnResult func(res *Result)
+}
+
+type Result struct {
+	Key     string `json:"key"`
+	ID      string `json:"id,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"` // already imported according to checkpoint
+	Error   error  `json:"-"`
+}
+
+// Summary counts the records processed by Import
+type Summary struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+}
+
+const DefaultWorkers = 4
+
+// Import creates all records provided by 'cmd.Source' concurrently. Failing
+// records don't stop the import, but are reported through 'cmd.OnResult'.
+// Cancelling 'ctxt' stops reading the source and aborts the calls in
+// flight, which are reported as failed. The returned error is only about
+// the source or the checkpoint.
+func Import(ctxt context.Context, cmd *ImportRequest, adpt *adapter.Adapter, logger *log.Logger) (Summary, error) {
+	feed := func(submit func(t task) error) error {
+		return cmd.Source(func(key string, r *record.CreateRequest) error {
+			t := task{key: key, id: r.Id}
+			if cmd.Checkpoint != nil && cmd.Checkpoint.Done(key) {
+				t.skip = true
+			} else if cmd.Update {
+				t.run = func() (string, error) {
+					_, err := record.UpdateRaw(ctxt, r, adpt, logger)
+					return r.Id, err
+				}
+			} else {
+				t.run = func() (string, error) {
+					// CreateRaw generates the ID of records without one
+					pld, err := record.CreateRaw(ctxt, r, adpt, logger)
+					return createdID(pld, r.Id), err
+				}
+			}
+			return submit(t)
+		})
+	}
+	var cpErr error
+	summary, err := runTasks(ctxt, cmd.Workers, feed, func(res *Result) {
+		if res.Error != nil {
+			logger.Warn("Importing record failed", log.String("key", res.Key), log.Error(res.Error))
+		} else if !res.Skipped && cmd.Checkpoint != nil && cpErr == nil {
+			cpErr = cmd.Checkpoint.Add(res.Key)
+		}
+		if cmd.OnResult != nil {
+			cmd.OnResult(res)
+		}
+	})
+	if err != nil {
+		return summary, err
+	}
+	return summary, cpErr
+}
+
+// createdID returns the ID of the record in the create response 'pld', or
+// 'id' if there is none
+func createdID(pld adapter.Payload, id string) string {
+	if pld == nil {
+		return id
+	}
+	if obj, err := pld.AsObject(); err == nil {
+		if s, ok := obj["id"].(string); ok && s != "" {
+			return s
+		}
+	}
+	return id
+}
+
+// task is a single call performed by runTasks
+type task struct {
+	key  string
+	id   string
+	skip bool
+	run  func() (string, error) // returns the ID of the record
+}
+
+// runTasks performs the tasks submitted by 'feed' with 'workers' concurrent
+// workers. 'onResult' is called for every task, but never concurrently. The
+// returned error is the one of 'feed', which is stopped when 'ctxt' is done.
+func runTasks(ctxt context.Context, workers int, feed func(submit func(t task) error) error, onResult func(res *Result)) (Summary, error) {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	tasks := make(chan task)
+	results := make(chan *Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				id, err := t.run()
+				results <- &Result{Key: t.key, ID: id, Error: err}
+			}
+		}()
+	}
+
+	// tasks are submitted in the background while results are handled here
+	feedErr := make(chan error, 1)
+	go func() {
+		err := feed(func(t task) error {
+			if t.skip {
+				results <- &Result{Key: t.key, ID: t.id, Skipped: true}
+				return nil
+			}
+			select {
+			case tasks <- t:
+				return nil
+			case <-ctxt.Done():
+				return ctxt.Err()
+			}
+		})
+		close(tasks)
+		wg.Wait()
+		close(results)
+		feedErr <- err
+	}()
+
+	var summary Summary
+	for res := range results {
+		switch {
+		case res.Skipped:
+			summary.Skipped++
+		case res.Error != nil:
+			summary.Failed++
+		default:
+			summary.Succeeded++
+		}
+		onResult(res)
+	}
+	return summary, <-feedErr
+}
+
+/**** DELETE ****/
+
+type DeleteRequest struct {
+	IDs     []string
+	Aspect  string // only delete this aspect of every record
+	Workers int    // number of concurrent calls, defaults to 4
+	// OnResult is called for every record processed. Calls are never concurrent.
+	OnResult func(res *Result)
+}
+
+// MatchingIDs returns the IDs of all records matching 'cmd', across all pages
+func MatchingIDs(ctxt context.Context, cmd *record.ListRequest, adpt *adapter.Adapter, logger *log.Logger) ([]string, error) {
+	ids := []string{}
+	it := record.ListAll(ctxt, cmd, adpt, logger)
+	for it.Next() {
+		ids = append(ids, it.Record().ID)
+	}
+	return ids, it.Err()
+}
+
+// Delete deletes the records, or just an aspect of them, listed in 'cmd.IDs'
+// concurrently. Failing records don't stop the others, but are reported
+// through 'cmd.OnResult'.
+func Delete(ctxt context.Context, cmd *DeleteRequest, adpt *adapter.Adapter, logger *log.Logger) (Summary, error) {
+	feed := func(submit func(t task) error) error {
+		for _, id := range cmd.IDs {
+			req := &record.DeleteRequest{Id: id, AspectName: cmd.Aspect}
+			err := submit(task{key: id, id: id, run: func() (string, error) {
+				_, err := record.DeleteRaw(ctxt, req, adpt, logger)
+				return req.Id, err
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return runTasks(ctxt, cmd.Workers, feed, func(res *Result) {
+		if res.Error != nil {
+			logger.Warn("Deleting record failed", log.String("id", res.ID), log.Error(res.Error))
+		}
+		if cmd.OnResult != nil {
+			cmd.OnResult(res)
+		}
+	})
+}
+
+/**** EXPORT ****/
+
+type ExportRequest struct {
+	List    record.ListRequest // 'Limit' sets the page size
+	Sink    Sink
+	Workers int // number of records written concurrently, defaults to 4
+	Max     int // stop after this many records, if > 0
+}
+
+// Export writes all records matching 'cmd.List' to 'cmd.Sink', following the
+// page tokens. If the request names no aspects, all aspects defined in the
+// registry are exported. The next page is already retrieved while the records of the
+// previous one are written. It returns the number of records written and
+// stops at the first error. The sink is not closed.
+func Export(ctxt context.Context, cmd *ExportRequest, adpt *adapter.Adapter, logger *log.Logger) (int, error) {
+	workers := cmd.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	list := cmd.List
+	if list.Aspects == "" && list.OptionalAspects == "" {
+		defs, err := schema.List(ctxt, &schema.ListRequest{}, adpt, logger)
+		if err != nil {
+			return 0, err
+		}
+		names := make([]string, len(defs))
+		for i, d := range defs {
+			names[i] = d.ID
+		}
+		list.OptionalAspects = strings.Join(names, ",")
+	}
+	ctxt, cancel := context.WithCancel(ctxt)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	count := 0
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	recs := make(chan *record.Record, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range recs {
+				if err := cmd.Sink.Write(r); err != nil {
+					fail(fmt.Errorf("writing record '%s' - %s", r.ID, err))
+					continue
+				}
+				mu.Lock()
+				count++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	it := record.ListAll(ctxt, &list, adpt, logger)
+	for n := 0; (cmd.Max <= 0 || n < cmd.Max) && it.Next(); n++ {
+		recs <- it.Record()
+	}
+	close(recs)
+	wg.Wait()
+	if firstErr == nil && it.Err() != nil {
+		firstErr = it.Err()
+	}
+	logger.Info("Exported records", log.Int("count", count))
+	return count, firstErr
+}
+
+/**** CHECK ****/
+
+type CheckRequest struct {
+	Aspect string
+	Schema *schema.Schema // the schema to check aspect 'Aspect' of all records against
+	Limit  int            // only check the first 'Limit' records, if > 0
+}
+
+// CheckReport lists the records failing a CheckRequest
+type CheckReport struct {
+	Aspect   string          `json:"aspect"`
+	Checked  int             `json:"checked"`
+	Failed   []RecordFailure `json:"failed"`
+	Complete bool            `json:"complete"` // false if stopped by 'Limit'
+}
+
+type RecordFailure struct {
+	ID     string              `json:"id"`
+	Errors []schema.FieldError `json:"errors"`
+}
+
+// maxCheckPageSize is the page size used by Check for small limits
+const maxCheckPageSize = 100
+
+// Check validates aspect 'cmd.Aspect' of all records having it against
+// 'cmd.Schema', following the page tokens, e.g. to find out which records a
+// new version of the schema would reject.
+func Check(ctxt context.Context, cmd *CheckRequest, adpt *adapter.Adapter, logger *log.Logger) (*CheckReport, error) {
+	list := record.ListRequest{Aspects: cmd.Aspect, Offset: -1, Limit: -1}
+	if cmd.Limit > 0 && cmd.Limit < maxCheckPageSize {
+		list.Limit = cmd.Limit
+	}
+	report := &CheckReport{Aspect: cmd.Aspect, Failed: []RecordFailure{}, Complete: true}
+	it := record.ListAll(ctxt, &list, adpt, logger)
+	for it.Next() {
+		if cmd.Limit > 0 && report.Checked >= cmd.Limit {
+			report.Complete = false
+			break
+		}
+		r := it.Record()
+		report.Checked++
+		err := cmd.Schema.Validate(r.Aspects[cmd.Aspect])
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			report.Failed = append(report.Failed, RecordFailure{ID: r.ID, Errors: verr.Errors})
+		} else if err != nil {
+			return report, fmt.Errorf("record '%s' - %s", r.ID, err)
+		}
+	}
+	logger.Info("Checked records", log.Int("count", report.Checked), log.Int("failed", len(report.Failed)))
+	return report, it.Err()
+}
@@ -0,0 +1,462 @@
+package bulk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	"github.com/maxott/magda-cli/pkg/record"
+	"github.com/maxott/magda-cli/pkg/schema"
+	log "go.uber.org/zap"
+)
+
+func collect(t *testing.T, src Source) map[string]*record.CreateRequest {
+	recs := map[string]*record.CreateRequest{}
+	if err := src(func(key string, r *record.CreateRequest) error {
+		recs[key] = r
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	return recs
+}
+
+func writeFile(t *testing.T, fileName string, content string) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONLinesSource(t *testing.T) {
+	recs := collect(t, JSONLinesSource(strings.NewReader(`{"id": "a", "name": "A", "aspects": {"x": {"v": 1}}}
+
+{"name": "no id", "aspects": {}}`)))
+	if len(recs) != 2 || recs["a"].Name != "A" || recs["a"].Aspects["x"]["v"] != 1.0 || recs["line:3"] == nil {
+		t.Fatalf("unexpected records %+v", recs)
+	}
+	err := JSONLinesSource(strings.NewReader("{\"id\": \"a\"}\nnot json\n"))(func(string, *record.CreateRequest) error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2 - ") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "r1", "x.json"), `{"v": 1}`)
+	writeFile(t, filepath.Join(dir, "r1", "y.yaml"), "v: 2\n")
+	writeFile(t, filepath.Join(dir, "r1", "notes.txt"), "ignored")
+	writeFile(t, filepath.Join(dir, "r2", DirRecordFile+".yaml"), "name: Second\nsourceTag: test\n")
+	writeFile(t, filepath.Join(dir, "README.md"), "ignored")
+
+	recs := collect(t, DirSource(dir))
+	r1, r2 := recs["r1"], recs["r2"]
+	if len(recs) != 2 || r1 == nil || r2 == nil {
+		t.Fatalf("unexpected records %+v", recs)
+	}
+	if r1.Name != "r1" || len(r1.Aspects) != 2 || r1.Aspects["x"]["v"] != 1.0 || r1.Aspects["y"]["v"] != 2.0 {
+		t.Errorf("unexpected record %+v", r1)
+	}
+	if r2.Name != "Second" || r2.SourceTag != "test" || len(r2.Aspects) != 0 {
+		t.Errorf("unexpected record %+v", r2)
+	}
+}
+
+func TestSinkNames(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "keep.txt"), "unrelated")
+	dir := filepath.Join(root, "out")
+	writeFile(t, filepath.Join(dir, "keep.txt"), "unrelated")
+	tarFile := filepath.Join(root, "out.tar.gz")
+
+	recs := []*record.Record{
+		{ID: "..", Name: "up", Aspects: map[string]interface{}{"..": map[string]interface{}{"v": 1}}},
+		{ID: ".", Name: "self", Aspects: map[string]interface{}{}},
+		{ID: ".hidden", Name: "hidden", Aspects: map[string]interface{}{"x": map[string]interface{}{}}},
+		{ID: "meta", Name: "meta", Aspects: map[string]interface{}{"_record": map[string]interface{}{"name": "aspect"}}},
+	}
+	dirSink, err := DirSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarSink, err := FileSink(tarFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sink := range []Sink{dirSink, tarSink} {
+		for _, r := range recs {
+			if err := sink.Write(r); err != nil {
+				t.Fatalf("unexpected error - %v", err)
+			}
+		}
+		if err := sink.Write(&record.Record{Name: "no ID"}); err == nil {
+			t.Errorf("expected error for empty ID")
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "keep.txt"), filepath.Join(dir, "keep.txt")} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("expected '%s' to be kept - %v", f, err)
+		}
+	}
+
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for format, src := range map[string]Source{"dir": DirSource(dir), "tar": TarSource(f)} {
+		got := collect(t, src)
+		if len(got) != 4 || got[".."] == nil || got["."] == nil || got[".hidden"] == nil || got["meta"] == nil {
+			t.Fatalf("%s: unexpected records %v", format, got)
+		}
+		if got[".."].Name != "up" || got[".."].Aspects[".."]["v"] != 1.0 || len(got[".hidden"].Aspects) != 1 {
+			t.Errorf("%s: unexpected records %+v, %+v", format, got[".."], got[".hidden"])
+		}
+		if got["meta"].Name != "meta" || got["meta"].Aspects["_record"]["name"] != "aspect" {
+			t.Errorf("%s: unexpected record %+v", format, got["meta"])
+		}
+	}
+
+	// aspects removed since the last export don't linger
+	recs[0].Aspects = map[string]interface{}{}
+	if err := dirSink.Write(recs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, DirSource(dir)); len(got[".."].Aspects) != 0 {
+		t.Errorf("unexpected aspects %+v", got[".."].Aspects)
+	}
+}
+
+func TestReadAspectsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cse-order.json"), `{"status": "pending"}`)
+	writeFile(t, filepath.Join(dir, "cse-service.yml"), "name: svc\n")
+	writeFile(t, filepath.Join(dir, DirRecordFile+".json"), `{"name": "ignored"}`)
+
+	aspects, err := ReadAspectsDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(aspects) != 2 || aspects["cse-order"]["status"] != "pending" || aspects["cse-service"]["name"] != "svc" {
+		t.Errorf("unexpected aspects %+v", aspects)
+	}
+	if _, err := ReadAspectsDir(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing directory")
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	mapping := &CSVMapping{ID: "order_id", Name: "title", Aspects: map[string]map[string]string{
+		"cse-order": {"status": "status", "parameters.amount": "amount:number", "parameters.urgent": "urgent:bool"},
+		"tags":      {"list": "tags:json"},
+	}}
+	csv := "order_id,title,status,amount,urgent,tags\n" +
+		"o1,First,pending,12.5,true,\"[\"\"a\"\"]\"\n" +
+		"o2,,done,,,\n"
+	recs := collect(t, CSVSource(strings.NewReader(csv), mapping))
+	o1, o2 := recs["o1"], recs["o2"]
+	if len(recs) != 2 || o1 == nil || o2 == nil {
+		t.Fatalf("unexpected records %+v", recs)
+	}
+	params, _ := o1.Aspects["cse-order"]["parameters"].(map[string]interface{})
+	if o1.Name != "First" || params["amount"] != 12.5 || params["urgent"] != true || o1.Aspects["tags"]["list"].([]interface{})[0] != "a" {
+		t.Errorf("unexpected record %+v", o1)
+	}
+	if o2.Name != "o2" || len(o2.Aspects) != 1 || len(o2.Aspects["cse-order"]) != 1 {
+		t.Errorf("unexpected record %+v", o2)
+	}
+
+	bad := &CSVMapping{ID: "id", Aspects: map[string]map[string]string{"a": {"v": "missing"}}}
+	err := CSVSource(strings.NewReader("id\n1\n"), bad)(func(string, *record.CreateRequest) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "unknown column 'missing'") {
+		t.Errorf("expected unknown column error, got %v", err)
+	}
+	err = CSVSource(strings.NewReader(csv), &CSVMapping{ID: "order_id", Aspects: map[string]map[string]string{
+		"a": {"v": "title:number"},
+	}})(func(string, *record.CreateRequest) error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "row 2, column 'title' - ") {
+		t.Errorf("expected conversion error, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	lines := `{"id": "a", "name": "A", "aspects": {}}
+{"id": "b", "name": "B", "aspects": {}}
+{"id": "a", "name": "A again", "aspects": {}}
+{"id": "c", "name": "C", "aspects": {}}
+`
+	var results []*Result
+	cmd := &ImportRequest{
+		Source:   JSONLinesSource(strings.NewReader(lines)),
+		Workers:  2,
+		OnResult: func(res *Result) { results = append(results, res) },
+	}
+	summary, err := Import(ctxt, cmd, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	// the duplicate 'a' fails, but only one of them
+	if summary != (Summary{Succeeded: 3, Failed: 1}) || len(results) != 4 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+
+	// updating replaces existing records
+	cmd = &ImportRequest{Source: JSONLinesSource(strings.NewReader(lines)), Update: true}
+	if summary, err = Import(ctxt, cmd, adpt, logger); err != nil || summary != (Summary{Succeeded: 4}) {
+		t.Fatalf("unexpected summary %+v, error %v", summary, err)
+	}
+
+	// records without an ID are reported with the generated one
+	results = nil
+	cmd = &ImportRequest{
+		Source:   JSONLinesSource(strings.NewReader(`{"name": "D", "aspects": {}}`)),
+		OnResult: func(res *Result) { results = append(results, res) },
+	}
+	if _, err = Import(ctxt, cmd, adpt, logger); err != nil || len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("unexpected results %+v, error %v", results, err)
+	}
+	if results[0].ID == "" {
+		t.Fatalf("expected generated ID in result")
+	}
+	if _, err := record.ReadRaw(ctxt, &record.ReadRequest{Id: results[0].ID}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error reading '%s' - %v", results[0].ID, err)
+	}
+}
+
+func TestImportCheckpoint(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	cpFile := filepath.Join(t.TempDir(), "checkpoint")
+	writeFile(t, cpFile, "a\n")
+	lines := `{"id": "a", "name": "A", "aspects": {}}
+{"id": "b", "name": "B", "aspects": {}}
+`
+	run := func() (Summary, []string) {
+		cp, err := OpenCheckpoint(cpFile)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		defer cp.Close()
+		var skipped []string
+		summary, err := Import(ctxt, &ImportRequest{
+			Source:     JSONLinesSource(strings.NewReader(lines)),
+			Checkpoint: cp,
+			OnResult: func(res *Result) {
+				if res.Skipped {
+					skipped = append(skipped, res.Key)
+				}
+			},
+		}, adpt, logger)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		sort.Strings(skipped)
+		return summary, skipped
+	}
+
+	summary, skipped := run()
+	if summary != (Summary{Succeeded: 1, Skipped: 1}) || len(skipped) != 1 || skipped[0] != "a" {
+		t.Fatalf("unexpected summary %+v, skipped %v", summary, skipped)
+	}
+	if _, err := record.ReadRaw(ctxt, &record.ReadRequest{Id: "a"}, adpt, logger); err == nil {
+		t.Errorf("record 'a' shouldn't have been created")
+	}
+	summary, skipped = run()
+	if summary != (Summary{Skipped: 2}) || len(skipped) != 2 {
+		t.Fatalf("unexpected summary %+v, skipped %v", summary, skipped)
+	}
+}
+
+func TestImportCancel(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	src := func(onRecord func(key string, r *record.CreateRequest) error) error {
+		for {
+			if err := onRecord("x", &record.CreateRequest{Id: "x", Name: "x"}); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := Import(ctxt, &ImportRequest{Source: src}, adpt, log.NewNop()); err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	for _, a := range []string{"x", "y"} {
+		if _, err := schema.CreateRaw(ctxt, &schema.CreateRequest{Id: a, Name: a, Schema: map[string]interface{}{}}, adpt, logger); err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+	}
+	lines := `{"id": "a", "name": "A", "sourceTag": "t", "aspects": {"x": {"v": 1}}}
+{"id": "b/1", "name": "B", "aspects": {"x": {"v": 2}, "y": {"w": [true]}}}
+{"id": "c", "name": "C", "aspects": {}}
+`
+	if _, err := Import(ctxt, &ImportRequest{Source: JSONLinesSource(strings.NewReader(lines))}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	dir := t.TempDir()
+	tarFile := filepath.Join(dir, "export.tar.gz")
+	var jsonl strings.Builder
+	sinks := map[string]Sink{"jsonl": JSONLinesSink(&jsonl)}
+	var err error
+	if sinks["dir"], err = DirSink(filepath.Join(dir, "export")); err != nil {
+		t.Fatal(err)
+	}
+	if sinks["tar"], err = FileSink(tarFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	for format, sink := range sinks {
+		// small pages to cover page tokens
+		n, err := Export(ctxt, &ExportRequest{List: record.ListRequest{Offset: -1, Limit: 2}, Sink: sink}, adpt, logger)
+		if err != nil || n != 3 {
+			t.Fatalf("%s: unexpected result %d, %v", format, n, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sources := map[string]Source{
+		"jsonl": JSONLinesSource(strings.NewReader(jsonl.String())),
+		"dir":   DirSource(filepath.Join(dir, "export")),
+		"tar":   TarSource(f),
+	}
+	for format, src := range sources {
+		recs := collect(t, src)
+		a, b, c := recs["a"], recs["b/1"], recs["c"]
+		if len(recs) != 3 || a == nil || b == nil || c == nil {
+			t.Fatalf("%s: unexpected records %v", format, recs)
+		}
+		if a.Name != "A" || a.SourceTag != "t" || a.Aspects["x"]["v"] != 1.0 {
+			t.Errorf("%s: unexpected record %+v", format, a)
+		}
+		if b.Name != "B" || len(b.Aspects) != 2 || b.Aspects["y"]["w"].([]interface{})[0] != true {
+			t.Errorf("%s: unexpected record %+v", format, b)
+		}
+		if c.Name != "C" || len(c.Aspects) != 0 {
+			t.Errorf("%s: unexpected record %+v", format, c)
+		}
+	}
+
+	// only records with the required aspects, and limited
+	var out strings.Builder
+	n, err := Export(ctxt, &ExportRequest{List: record.ListRequest{Aspects: "y", Offset: -1, Limit: -1}, Sink: JSONLinesSink(&out)}, adpt, logger)
+	if err != nil || n != 1 || !strings.Contains(out.String(), `"id":"b/1"`) || strings.Contains(out.String(), `"x"`) {
+		t.Errorf("unexpected export %d, %v: %s", n, err, out.String())
+	}
+	n, err = Export(ctxt, &ExportRequest{List: record.ListRequest{Offset: -1, Limit: -1}, Sink: JSONLinesSink(&out), Max: 2}, adpt, logger)
+	if err != nil || n != 2 {
+		t.Errorf("unexpected export %d, %v", n, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	lines := `{"id": "a", "name": "A", "aspects": {"x": {"status": "test"}, "y": {}}}
+{"id": "b", "name": "B", "aspects": {"x": {"status": "test"}}}
+{"id": "c", "name": "C", "aspects": {"x": {"status": "live"}, "y": {}}}
+`
+	// a single worker keeps the order of the records
+	if _, err := Import(ctxt, &ImportRequest{Source: JSONLinesSource(strings.NewReader(lines)), Workers: 1}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	ids, err := MatchingIDs(ctxt, &record.ListRequest{Aspects: "y", Offset: -1, Limit: 1}, adpt, logger)
+	if err != nil || strings.Join(ids, ",") != "a,c" {
+		t.Fatalf("unexpected IDs %v, error %v", ids, err)
+	}
+
+	// only the aspect
+	summary, err := Delete(ctxt, &DeleteRequest{IDs: ids, Aspect: "y"}, adpt, logger)
+	if err != nil || summary != (Summary{Succeeded: 2}) {
+		t.Fatalf("unexpected summary %+v, error %v", summary, err)
+	}
+	if ids, _ = MatchingIDs(ctxt, &record.ListRequest{Aspects: "y", Offset: -1, Limit: -1}, adpt, logger); len(ids) != 0 {
+		t.Fatalf("expected no records with aspect 'y', got %v", ids)
+	}
+
+	query := []record.QueryTerm{{Path: "x.status", Op: record.Equal, Value: "test"}}
+	ids, _ = MatchingIDs(ctxt, &record.ListRequest{AndQuery: query, Offset: -1, Limit: -1}, adpt, logger)
+	var deleted []string
+	summary, err = Delete(ctxt, &DeleteRequest{IDs: ids, Workers: 1, OnResult: func(res *Result) {
+		deleted = append(deleted, res.ID)
+	}}, adpt, logger)
+	if err != nil || summary != (Summary{Succeeded: 2}) || strings.Join(deleted, ",") != "a,b" {
+		t.Fatalf("unexpected summary %+v, deleted %v, error %v", summary, deleted, err)
+	}
+	if ids, _ = MatchingIDs(ctxt, &record.ListRequest{Offset: -1, Limit: -1}, adpt, logger); strings.Join(ids, ",") != "c" {
+		t.Fatalf("unexpected remaining records %v", ids)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+	lines := `{"id": "a", "name": "A", "aspects": {"x": {"status": "done"}}}
+{"id": "b", "name": "B", "aspects": {"x": {"status": 5}}}
+{"id": "c", "name": "C", "aspects": {"x": {}}}
+{"id": "d", "name": "D", "aspects": {"y": {}}}
+`
+	// a single worker keeps the order of the records
+	if _, err := Import(ctxt, &ImportRequest{Source: JSONLinesSource(strings.NewReader(lines)), Workers: 1}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	s, err := schema.Compile("x", map[string]interface{}{
+		"type": "object", "required": []interface{}{"status"},
+		"properties": map[string]interface{}{"status": map[string]interface{}{"type": "string"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	report, err := Check(ctxt, &CheckRequest{Aspect: "x", Schema: s}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if report.Checked != 3 || !report.Complete || len(report.Failed) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if f := report.Failed[0]; f.ID != "b" || len(f.Errors) != 1 || f.Errors[0].Pointer != "/status" {
+		t.Errorf("unexpected failure %+v", f)
+	}
+	if f := report.Failed[1]; f.ID != "c" || f.Errors[0].Pointer != "/" {
+		t.Errorf("unexpected failure %+v", f)
+	}
+
+	report, err = Check(ctxt, &CheckRequest{Aspect: "x", Schema: s, Limit: 2}, adpt, logger)
+	if err != nil || report.Checked != 2 || report.Complete || len(report.Failed) != 1 {
+		t.Errorf("unexpected report %+v, error %v", report, err)
+	}
+	report, err = Check(ctxt, &CheckRequest{Aspect: "x", Schema: s, Limit: 3}, adpt, logger)
+	if err != nil || report.Checked != 3 || !report.Complete {
+		t.Errorf("unexpected report %+v, error %v", report, err)
+	}
+}
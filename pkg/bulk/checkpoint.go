@@ -0,0 +1,73 @@
+package bulk
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Checkpoint keeps track of the records already processed in a file, with
+// one key per line. Keys are appended as soon as a record succeeds, so that
+// an interrupted run can be resumed by skipping them.
+type Checkpoint struct {
+	done map[string]bool
+	file *os.File
+	mu   sync.Mutex
+}
+
+// OpenCheckpoint loads the keys from 'fileName', creating it if necessary
+func OpenCheckpoint(fileName string) (*Checkpoint, error) {
+	cp := &Checkpoint{done: map[string]bool{}}
+	if f, err := os.Open(fileName); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key := strings.TrimSpace(scanner.Text()); key != "" {
+				cp.done[key] = true
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading checkpoint '%s' - %s", fileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp.file = f
+	return cp, nil
+}
+
+// Done returns true if 'key' has already been processed
+func (cp *Checkpoint) Done(key string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.done[key]
+}
+
+// Len returns the number of keys processed so far
+func (cp *Checkpoint) Len() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.done)
+}
+
+// Add marks 'key' as processed
+func (cp *Checkpoint) Add(key string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.done[key] {
+		return nil
+	}
+	cp.done[key] = true
+	_, err := fmt.Fprintln(cp.file, key)
+	return err
+}
+
+func (cp *Checkpoint) Close() error {
+	return cp.file.Close()
+}
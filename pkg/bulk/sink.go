@@ -0,0 +1,292 @@
+package bulk
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/record"
+)
+
+// Sink receives the exported records. Implementations are safe for
+// concurrent use.
+type Sink interface {
+	Write(r *record.Record) error
+	Close() error
+}
+
+// Formats supported by FileSink
+const (
+	FormatJSONLines = "jsonl"
+	FormatDir       = "dir"
+	FormatTar       = "tar"
+)
+
+// DetectFormat returns the format matching the name of 'fileName': a tar
+// archive for '.tar.gz' and '.tgz', JSON Lines for '.jsonl', '.json' and '-'
+// (stdout), and a directory otherwise.
+func DetectFormat(fileName string) string {
+	name := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return FormatTar
+	case name == "-" || strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".json"):
+		return FormatJSONLines
+	default:
+		return FormatDir
+	}
+}
+
+// FileSink creates a sink writing to 'fileName' in 'format', which is
+// detected from the name if empty. "-" writes to stdout.
+func FileSink(fileName string, format string) (Sink, error) {
+	if format == "" {
+		format = DetectFormat(fileName)
+	}
+	switch format {
+	case FormatDir:
+		if fileName == "-" {
+			return nil, fmt.Errorf("can't write a directory to stdout")
+		}
+		return DirSink(fileName)
+	case FormatJSONLines, FormatTar:
+		var w io.WriteCloser = nopCloser{os.Stdout}
+		if fileName != "-" {
+			f, err := os.Create(fileName)
+			if err != nil {
+				return nil, err
+			}
+			w = f
+		}
+		if format == FormatTar {
+			return &closingSink{TarSink(w), w}, nil
+		}
+		return &closingSink{JSONLinesSink(w), w}, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+}
+
+/**** JSON LINES ****/
+
+type jsonLinesSink struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// JSONLinesSink writes every record as a single line of JSON
+func JSONLinesSink(w io.Writer) Sink {
+	return &jsonLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonLinesSink) Write(r *record.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(r)
+}
+
+func (s *jsonLinesSink) Close() error {
+	return nil
+}
+
+/**** DIRECTORY ****/
+
+type dirSink struct {
+	dir string
+}
+
+// DirSink writes records as '<recordId>/<aspect>.json' below 'dir', as read
+// by DirSource. Name and source tag go to '_record.json', so a leading '_'
+// of an aspect name is escaped as '%5F'. Aspect files left
+// in an existing record directory from an earlier export are removed, so
+// aspects removed in the meantime don't linger.
+func DirSink(dir string) (Sink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &dirSink{dir}, nil
+}
+
+func (s *dirSink) Write(r *record.Record) error {
+	files, err := recordFiles(r)
+	if err != nil {
+		return err
+	}
+	name, err := escapeName(r.ID)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(s.dir, name)
+	if rel, err := filepath.Rel(s.dir, dir); err != nil || rel != name {
+		return fmt.Errorf("record '%s' - directory '%s' is outside of '%s'", r.ID, dir, s.dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	written := map[string]bool{}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), f.data, 0644); err != nil {
+			return err
+		}
+		written[f.name] = true
+	}
+	existing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" && !written[e.Name()] {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
+
+/**** TAR ****/
+
+type tarSink struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+// TarSink writes a gzipped tar archive with the same layout as DirSink.
+// Closing the sink doesn't close 'w'.
+func TarSink(w io.Writer) Sink {
+	gz := gzip.NewWriter(w)
+	return &tarSink{gz: gz, tw: tar.NewWriter(gz), modTime: time.Now()}
+}
+
+func (s *tarSink) Write(r *record.Record) error {
+	files, err := recordFiles(r)
+	if err != nil {
+		return err
+	}
+	dir, err := escapeName(r.ID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// all files of a record are written together, see TarSource
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    path.Join(dir, f.name),
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: s.modTime,
+		}
+		if err := s.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := s.tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *tarSink) Close() error {
+	if err := s.tw.Close(); err != nil {
+		return err
+	}
+	return s.gz.Close()
+}
+
+/**** HELPERS ****/
+
+type recordFile struct {
+	name string
+	data []byte
+}
+
+// recordFiles returns the files representing 'r' in a directory
+func recordFiles(r *record.Record) ([]recordFile, error) {
+	meta := map[string]string{"name": r.Name}
+	if r.SourceTag != "" {
+		meta["sourceTag"] = r.SourceTag
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files := []recordFile{{DirRecordFile + ".json", append(data, '\n')}}
+	for _, name := range sortedKeys(r.Aspects) {
+		data, err := json.MarshalIndent(r.Aspects[name], "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("aspect '%s' of record '%s' - %s", name, r.ID, err)
+		}
+		fileName, err := escapeName(name)
+		if err != nil {
+			return nil, fmt.Errorf("aspect of record '%s' - %s", r.ID, err)
+		}
+		if strings.HasPrefix(fileName, "_") {
+			// keep aspects like '_record' apart from DirRecordFile
+			fileName = "%5F" + fileName[1:]
+		}
+		files = append(files, recordFile{fileName + ".json", append(data, '\n')})
+	}
+	return files, nil
+}
+
+// escapeName turns a record ID or aspect name into a file name, which is
+// never '.', '..' or hidden, and is restored by 'url.PathUnescape'
+func escapeName(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty name")
+	}
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closingSink also closes the underlying file
+type closingSink struct {
+	Sink
+	w io.Closer
+}
+
+func (s *closingSink) Close() error {
+	err := s.Sink.Close()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,410 @@
+package bulk
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/record"
+)
+
+// JSONLinesSource reads one complete record ('id', 'name', 'aspects' and
+// optional 'sourceTag') per line. Empty lines are ignored.
+func JSONLinesSource(r io.Reader) Source {
+	return func(onRecord func(key string, r *record.CreateRequest) error) error {
+		reader := bufio.NewReader(r)
+		for lineNo := 1; ; lineNo++ {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if len(strings.TrimSpace(string(line))) > 0 {
+				var rec record.CreateRequest
+				if err := json.Unmarshal(line, &rec); err != nil {
+					return fmt.Errorf("line %d - %s", lineNo, err)
+				}
+				key := rec.Id
+				if key == "" {
+					key = "line:" + strconv.Itoa(lineNo)
+				}
+				if err := onRecord(key, &rec); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+		}
+	}
+}
+
+// DirRecordFile is the optional file in a record's directory defining its
+// 'name' and 'sourceTag'. The name defaults to the record ID.
+const DirRecordFile = "_record"
+
+// DirSource reads records from a directory tree laid out as
+// '<recordId>/<aspect>.json' (or '.yaml', '.yml'). Record IDs and aspect names
+// are path escaped, as written by DirSink.
+func DirSource(dir string) Source {
+	return func(onRecord func(key string, r *record.CreateRequest) error) error {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			rec, err := readRecordDir(filepath.Join(dir, e.Name()), e.Name())
+			if err != nil {
+				return err
+			}
+			if err := onRecord(rec.Id, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readRecordDir(dir string, dirName string) (*record.CreateRequest, error) {
+	rec := newDirRecord(dirName)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		fileName := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if err := addRecordFile(rec, f.Name(), data); err != nil {
+			return nil, fmt.Errorf("loading '%s' - %s", fileName, err)
+		}
+	}
+	return rec, nil
+}
+
+// ReadAspectsDir loads the aspect files in 'dir', laid out like the record
+// directories of DirSource. A DirRecordFile is ignored.
+func ReadAspectsDir(dir string) (record.Aspects, error) {
+	rec, err := readRecordDir(dir, filepath.Base(dir))
+	if err != nil {
+		return nil, err
+	}
+	return rec.Aspects, nil
+}
+
+// TarSource reads records from a gzipped tar archive with the same layout as
+// DirSource. All files of a record are expected to follow each other, as
+// written by TarSink.
+func TarSource(r io.Reader) Source {
+	return func(onRecord func(key string, r *record.CreateRequest) error) error {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		var rec *record.CreateRequest
+		var dirName string
+		for {
+			hdr, err := tr.Next()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			var name string
+			var dn string
+			if err == nil {
+				if hdr.Typeflag != tar.TypeReg {
+					continue
+				}
+				dn, name = path.Split(strings.TrimPrefix(path.Clean(hdr.Name), "./"))
+				dn = strings.TrimSuffix(dn, "/")
+				if dn == "" || strings.Contains(dn, "/") {
+					continue // not '<recordId>/<aspect>.json'
+				}
+			}
+			if rec != nil && (err == io.EOF || dn != dirName) {
+				if err := onRecord(rec.Id, rec); err != nil {
+					return err
+				}
+				rec = nil
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if rec == nil {
+				rec, dirName = newDirRecord(dn), dn
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := addRecordFile(rec, name, data); err != nil {
+				return fmt.Errorf("loading '%s' - %s", hdr.Name, err)
+			}
+		}
+	}
+}
+
+func newDirRecord(dirName string) *record.CreateRequest {
+	id, err := url.PathUnescape(dirName)
+	if err != nil {
+		id = dirName
+	}
+	return &record.CreateRequest{Id: id, Name: id, Aspects: record.Aspects{}}
+}
+
+// addRecordFile adds the content of an aspect or DirRecordFile file to 'rec'.
+// Other files are ignored.
+func addRecordFile(rec *record.CreateRequest, fileName string, data []byte) error {
+	ext := filepath.Ext(fileName)
+	isYAML := ext == ".yaml" || ext == ".yml"
+	if !(isYAML || ext == ".json") || strings.HasPrefix(fileName, ".") {
+		return nil
+	}
+	pld, err := adapter.LoadPayloadFromBytes(data, isYAML)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(fileName, ext)
+	if name == DirRecordFile {
+		var r struct {
+			Name      string `json:"name"`
+			SourceTag string `json:"sourceTag"`
+		}
+		if err := pld.AsType(&r); err != nil {
+			return err
+		}
+		if r.Name != "" {
+			rec.Name = r.Name
+		}
+		rec.SourceTag = r.SourceTag
+		return nil
+	}
+	obj, err := pld.AsObject()
+	if err != nil {
+		return err
+	}
+	if n, err := url.PathUnescape(name); err == nil {
+		name = n
+	}
+	rec.Aspects[name] = obj
+	return nil
+}
+
+// CSVMapping defines how the columns of a CSV file map to records. Aspect
+// fields are given as 'path.to.field: column', where the column can be
+// followed by the type of the value, like 'amount:number'. Supported types are
+// 'string' (default), 'number', 'bool' and 'json'. Empty cells are skipped.
+//
+//	id: order_id
+//	name: title
+//	aspects:
+//	  cse-order:
+//	    status: status
+//	    parameters.amount: amount:number
+type CSVMapping struct {
+	ID        string                       `json:"id"`
+	Name      string                       `json:"name"`
+	SourceTag string                       `json:"sourceTag"`
+	Aspects   map[string]map[string]string `json:"aspects"`
+}
+
+type csvField struct {
+	path   []string
+	column int
+	typ    string
+}
+
+// CSVSource reads one record per row of a CSV file with a header row
+func CSVSource(r io.Reader, mapping *CSVMapping) Source {
+	return func(onRecord func(key string, r *record.CreateRequest) error) error {
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("reading CSV header - %s", err)
+		}
+		columns := map[string]int{}
+		for i, h := range header {
+			columns[strings.TrimSpace(h)] = i
+		}
+		column := func(name string) (int, error) {
+			if name == "" {
+				return -1, nil
+			}
+			if i, ok := columns[name]; ok {
+				return i, nil
+			}
+			return -1, fmt.Errorf("mapping refers to unknown column '%s'", name)
+		}
+		idCol, err := column(mapping.ID)
+		if err != nil {
+			return err
+		}
+		nameCol, err := column(mapping.Name)
+		if err != nil {
+			return err
+		}
+		tagCol, err := column(mapping.SourceTag)
+		if err != nil {
+			return err
+		}
+		fields := map[string][]csvField{}
+		for aspect, m := range mapping.Aspects {
+			for path, col := range m {
+				f := csvField{path: strings.Split(path, "."), typ: "string"}
+				if i := strings.LastIndex(col, ":"); i > 0 {
+					col, f.typ = col[:i], col[i+1:]
+				}
+				if f.column, err = column(col); err != nil {
+					return err
+				}
+				if f.column < 0 {
+					return fmt.Errorf("missing column for field '%s' of aspect '%s'", path, aspect)
+				}
+				switch f.typ {
+				case "string", "number", "bool", "json":
+				default:
+					return fmt.Errorf("unknown type '%s' for column '%s'", f.typ, col)
+				}
+				fields[aspect] = append(fields[aspect], f)
+			}
+			// for deterministic results when paths overlap
+			sort.Slice(fields[aspect], func(i, j int) bool {
+				return strings.Join(fields[aspect][i].path, ".") < strings.Join(fields[aspect][j].path, ".")
+			})
+		}
+
+		for rowNo := 2; ; rowNo++ {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			cell := func(i int) string {
+				if i < 0 || i >= len(row) {
+					return ""
+				}
+				return strings.TrimSpace(row[i])
+			}
+			rec := &record.CreateRequest{Id: cell(idCol), Name: cell(nameCol), SourceTag: cell(tagCol), Aspects: record.Aspects{}}
+			if rec.Name == "" {
+				rec.Name = rec.Id
+			}
+			for aspect, fs := range fields {
+				a := record.Aspect{}
+				for _, f := range fs {
+					s := cell(f.column)
+					if s == "" {
+						continue
+					}
+					v, err := csvValue(s, f.typ)
+					if err != nil {
+						return fmt.Errorf("row %d, column '%s' - %s", rowNo, header[f.column], err)
+					}
+					setField(a, f.path, v)
+				}
+				if len(a) > 0 {
+					rec.Aspects[aspect] = a
+				}
+			}
+			key := rec.Id
+			if key == "" {
+				key = "row:" + strconv.Itoa(rowNo)
+			}
+			if err := onRecord(key, rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// LoadCSVMapping reads a CSVMapping from a JSON or YAML file
+func LoadCSVMapping(fileName string) (*CSVMapping, error) {
+	ext := filepath.Ext(fileName)
+	pld, err := adapter.LoadPayloadFromFile(fileName, ext == ".yaml" || ext == ".yml")
+	if err != nil {
+		return nil, err
+	}
+	var m CSVMapping
+	if err := pld.AsType(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func csvValue(s string, typ string) (interface{}, error) {
+	switch typ {
+	case "number":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "json":
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		return v, err
+	default:
+		return s, nil
+	}
+}
+
+func setField(obj map[string]interface{}, path []string, v interface{}) {
+	for _, p := range path[:len(path)-1] {
+		child, ok := obj[p].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[p] = child
+		}
+		obj = child
+	}
+	obj[path[len(path)-1]] = v
+}
+
+// FileSource picks the source matching 'fileName': a directory, a gzipped tar
+// archive ('.tar.gz' or '.tgz'), a CSV file (requires 'mapping'), or JSON
+// Lines otherwise. The returned function closes
+// any opened file.
+func FileSource(fileName string, mapping *CSVMapping) (Source, func() error, error) {
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return DirSource(fileName), func() error { return nil }, nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if DetectFormat(fileName) == FormatTar {
+		return TarSource(f), f.Close, nil
+	}
+	if strings.ToLower(filepath.Ext(fileName)) == ".csv" {
+		if mapping == nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("importing CSV file '%s' requires a mapping", fileName)
+		}
+		return CSVSource(f, mapping), f.Close, nil
+	}
+	return JSONLinesSource(f), f.Close, nil
+}
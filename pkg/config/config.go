@@ -0,0 +1,209 @@
+// Named connection profiles, stored in a YAML file
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	CurrentProfile string              `yaml:"current-profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles,omitempty"`
+
+	fileName string
+}
+
+// Profile holds the connection settings for a Magda deployment. The keys match
+// the names of the corresponding global flags.
+type Profile struct {
+	Host        string `yaml:"host,omitempty"`
+	URL         string `yaml:"url,omitempty"`
+	TenantID    string `yaml:"tenant-id,omitempty"`
+	AuthID      string `yaml:"auth-id,omitempty"`
+	AuthKey     string `yaml:"auth-key,omitempty"`
+	UseTLS      bool   `yaml:"use-tls,omitempty"`
+	SkipGateway bool   `yaml:"skip-gateway,omitempty"`
+	JwtSecret   string `yaml:"jwt-secret,omitempty"`
+	JwtUserID   string `yaml:"jwt-user-id,omitempty"`
+}
+
+// Keys lists all settings of a profile, in the order they are displayed
+var Keys = []string{"host", "url", "tenant-id", "auth-id", "auth-key", "use-tls", "skip-gateway", "jwt-secret", "jwt-user-id"}
+
+// SecretKeys lists the settings which should not be displayed by default
+var SecretKeys = map[string]bool{"auth-key": true, "jwt-secret": true}
+
+// DefaultFileName returns '$XDG_CONFIG_HOME/magda-cli/config.yaml', which
+// usually resolves to '~/.config/magda-cli/config.yaml'
+func DefaultFileName() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "magda-cli", "config.yaml"), nil
+}
+
+// Load reads the config from 'fileName'. A missing file results in an empty config.
+func Load(fileName string) (*Config, error) {
+	c := &Config{fileName: fileName}
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		c.Profiles = map[string]*Profile{}
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing config file '%s' - %s", fileName, err)
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]*Profile{}
+	}
+	return c, nil
+}
+
+// Save writes the config back to the file it was loaded from. As it may
+// contain secrets, the file is only readable by the user.
+func (c *Config) Save() error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.fileName), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.fileName, b, 0600)
+}
+
+// FileName returns the name of the file the config was loaded from
+func (c *Config) FileName() string {
+	return c.fileName
+}
+
+// Names returns the sorted names of all profiles
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for n := range c.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the profile 'name', or the current profile if 'name' is empty.
+// Returns nil if neither are defined.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		if c.CurrentProfile == "" {
+			return nil, nil
+		}
+		name = c.CurrentProfile
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile '%s'", name)
+	}
+	return p, nil
+}
+
+// Use makes 'name' the current profile
+func (c *Config) Use(name string) error {
+	if _, ok := c.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile '%s'", name)
+	}
+	c.CurrentProfile = name
+	return nil
+}
+
+// Set changes setting 'key' of profile 'name', creating the profile if needed.
+// An empty 'value' resets the setting. The first profile created also becomes
+// the current one.
+func (c *Config) Set(name string, key string, value string) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		p = &Profile{}
+	}
+	if err := p.Set(key, value); err != nil {
+		return err
+	}
+	c.Profiles[name] = p
+	if c.CurrentProfile == "" {
+		c.CurrentProfile = name
+	}
+	return nil
+}
+
+// Set changes setting 'key', where an empty 'value' resets it
+func (p *Profile) Set(key string, value string) error {
+	switch key {
+	case "host":
+		p.Host = value
+	case "url":
+		p.URL = value
+	case "tenant-id":
+		p.TenantID = value
+	case "auth-id":
+		p.AuthID = value
+	case "auth-key":
+		p.AuthKey = value
+	case "jwt-secret":
+		p.JwtSecret = value
+	case "jwt-user-id":
+		p.JwtUserID = value
+	case "use-tls", "skip-gateway":
+		b := false
+		if value != "" {
+			var err error
+			if b, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("setting '%s' expects true or false, but got '%s'", key, value)
+			}
+		}
+		if key == "use-tls" {
+			p.UseTLS = b
+		} else {
+			p.SkipGateway = b
+		}
+	default:
+		return fmt.Errorf("unknown setting '%s'", key)
+	}
+	return nil
+}
+
+// Get returns setting 'key' as string, or "" if it isn't set
+func (p *Profile) Get(key string) string {
+	switch key {
+	case "host":
+		return p.Host
+	case "url":
+		return p.URL
+	case "tenant-id":
+		return p.TenantID
+	case "auth-id":
+		return p.AuthID
+	case "auth-key":
+		return p.AuthKey
+	case "jwt-secret":
+		return p.JwtSecret
+	case "jwt-user-id":
+		return p.JwtUserID
+	case "use-tls":
+		if p.UseTLS {
+			return "true"
+		}
+	case "skip-gateway":
+		if p.SkipGateway {
+			return "true"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetUseSave(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "magda-cli", "config.yaml")
+	c, err := Load(fileName)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if p, _ := c.Profile(""); p != nil {
+		t.Fatalf("expected no current profile, but got %+v", p)
+	}
+	for _, kv := range [][3]string{
+		{"dev", "host", "localhost:6100"},
+		{"prod", "host", "magda.example.com"},
+		{"prod", "use-tls", "true"},
+		{"prod", "auth-key", "secret"},
+	} {
+		if err := c.Set(kv[0], kv[1], kv[2]); err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+	}
+	if err := c.Set("prod", "use-tls", "maybe"); err == nil {
+		t.Fatalf("expected error for illegal boolean")
+	}
+	if err := c.Set("prod", "colour", "blue"); err == nil {
+		t.Fatalf("expected error for unknown setting")
+	}
+	if c.CurrentProfile != "dev" {
+		t.Fatalf("expected first profile to become current, but got '%s'", c.CurrentProfile)
+	}
+	if err := c.Use("staging"); err == nil {
+		t.Fatalf("expected error for unknown profile")
+	}
+	if err := c.Use("prod"); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if s, _ := os.Stat(fileName); s.Mode().Perm() != 0600 {
+		t.Fatalf("config file should only be readable by user, but is %v", s.Mode())
+	}
+
+	c, err = Load(fileName)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	p, err := c.Profile("")
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if p.Host != "magda.example.com" || !p.UseTLS || p.Get("auth-key") != "secret" || p.Get("skip-gateway") != "" {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+	if names := c.Names(); len(names) != 2 || names[0] != "dev" {
+		t.Fatalf("unexpected profile names %v", names)
+	}
+}
@@ -0,0 +1,185 @@
+package fakeregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maxott/magda-cli/pkg/record"
+)
+
+// aspectQuery is a parsed 'aspectQuery' or 'aspectOrQuery' parameter of the
+// form 'aspectId.path.to.field:<op>value'
+type aspectQuery struct {
+	aspect string
+	path   []string
+	op     record.QueryOp
+	value  string
+}
+
+// operators sharing a prefix need to be listed before the shorter one
+var queryOps = []record.QueryOp{
+	record.NotMatchPattern, record.NotMatchRegExp, record.GreaterEqualThan, record.LessEqualThen,
+	record.NotEqual, record.MatchPattern, record.MatchRegExp, record.GreaterThan, record.LessThan,
+}
+
+func parseAspectQueries(qs []string) ([]*aspectQuery, error) {
+	res := []*aspectQuery{}
+	for _, q := range qs {
+		aq, err := parseAspectQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, aq)
+	}
+	return res, nil
+}
+
+func parseAspectQuery(q string) (*aspectQuery, error) {
+	i := strings.Index(q, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid aspect query '%s', missing ':'", q)
+	}
+	path := strings.Split(q[:i], ".")
+	if len(path) < 2 || path[0] == "" {
+		return nil, fmt.Errorf("Invalid aspect query '%s', path needs to be 'aspectId.field'", q)
+	}
+	aq := &aspectQuery{aspect: path[0], path: path[1:], op: record.Equal}
+	v := q[i+1:]
+	for _, op := range queryOps {
+		if strings.HasPrefix(v, string(op)) {
+			aq.op = op
+			v = v[len(op):]
+			break
+		}
+	}
+	if uv, err := url.QueryUnescape(v); err == nil {
+		v = uv
+	}
+	aq.value = v
+	return aq, nil
+}
+
+func matchAll(r *fakeRecord, qs []*aspectQuery) bool {
+	for _, q := range qs {
+		if !q.matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(r *fakeRecord, qs []*aspectQuery) bool {
+	if len(qs) == 0 {
+		return true
+	}
+	for _, q := range qs {
+		if q.matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *aspectQuery) matches(r *fakeRecord) bool {
+	v, ok := r.Aspects[q.aspect]
+	if !ok {
+		return false
+	}
+	for _, tok := range q.path {
+		switch n := v.(type) {
+		case map[string]interface{}:
+			if v, ok = n[tok]; !ok {
+				return false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return false
+			}
+			v = n[idx]
+		default:
+			return false
+		}
+	}
+	if v == nil {
+		return false
+	}
+	s := asText(v)
+
+	switch q.op {
+	case record.Equal:
+		return compare(s, q.value) == 0
+	case record.NotEqual:
+		return compare(s, q.value) != 0
+	case record.GreaterThan:
+		return compare(s, q.value) > 0
+	case record.GreaterEqualThan:
+		return compare(s, q.value) >= 0
+	case record.LessThan:
+		return compare(s, q.value) < 0
+	case record.LessEqualThen:
+		return compare(s, q.value) <= 0
+	case record.MatchPattern, record.NotMatchPattern:
+		re, err := regexp.Compile("(?is)^" + likeToRegexp(q.value) + "$")
+		return err == nil && re.MatchString(s) == (q.op == record.MatchPattern)
+	case record.MatchRegExp, record.NotMatchRegExp:
+		re, err := regexp.Compile("(?i)" + q.value)
+		return err == nil && re.MatchString(s) == (q.op == record.MatchRegExp)
+	default:
+		return false
+	}
+}
+
+// asText returns the value as Postgresql's '#>>' operator would
+func asText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+// compare compares numerically if both are numbers, and lexically otherwise
+func compare(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// likeToRegexp converts an SQL LIKE pattern into a regular expression
+func likeToRegexp(p string) string {
+	var sb strings.Builder
+	for _, c := range p {
+		switch c {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,386 @@
+package fakeregistry
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/record"
+)
+
+func (reg *Registry) serveRecords(s *store, segs []string, w http.ResponseWriter, req *http.Request) {
+	switch {
+	case len(segs) == 0 || segs[0] == "":
+		switch req.Method {
+		case http.MethodGet:
+			reg.listRecords(s, w, req)
+		case http.MethodPost:
+			reg.createRecord(s, w, req)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case len(segs) == 2 && segs[0] == "summary":
+		reg.recordSummary(s, segs[1], w, req)
+	case len(segs) == 1:
+		reg.serveRecord(s, segs[0], w, req)
+	case len(segs) == 3 && segs[1] == "aspects":
+		reg.serveRecordAspect(s, segs[0], segs[2], w, req)
+	case len(segs) == 2 && segs[1] == "history":
+		reg.listHistory(s, segs[0], w, req)
+	case len(segs) == 3 && segs[1] == "history":
+		reg.historyEvent(s, segs[0], segs[2], w, req)
+	default:
+		writeError(w, http.StatusNotFound, "The requested resource could not be found.")
+	}
+}
+
+/**** LIST ****/
+
+func (reg *Registry) listRecords(s *store, w http.ResponseWriter, req *http.Request) {
+	aspects := queryList(req, "aspect")
+	optAspects := queryList(req, "optionalAspect")
+	andQ, err := parseAspectQueries(req.URL.Query()["aspectQuery"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	orQ, err := parseAspectQueries(req.URL.Query()["aspectOrQuery"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	matches := []*fakeRecord{}
+	for _, id := range s.order {
+		r := s.records[id]
+		if hasAspects(r, aspects) && matchAll(r, andQ) && matchAny(r, orQ) {
+			matches = append(matches, r)
+		}
+	}
+	from, to, hasMore := pageBounds(req, len(matches))
+	res := struct {
+		HasMore       bool          `json:"hasMore"`
+		NextPageToken string        `json:"nextPageToken,omitempty"`
+		Records       []*fakeRecord `json:"records"`
+	}{HasMore: hasMore, Records: []*fakeRecord{}}
+	for _, r := range matches[from:to] {
+		res.Records = append(res.Records, view(r, aspects, optAspects))
+	}
+	if hasMore {
+		res.NextPageToken = strconv.Itoa(to)
+	}
+	writeJSON(w, http.StatusOK, &res)
+}
+
+// pageBounds returns the range of 'n' items requested through 'pageToken', 'start', and
+// 'limit', as well as if there are more items after it.
+func pageBounds(req *http.Request, n int) (int, int, bool) {
+	from := queryInt(req, "pageToken", 0) + queryInt(req, "start", 0)
+	if from > n {
+		from = n
+	}
+	to := from + queryInt(req, "limit", defaultLimit)
+	if to > n {
+		to = n
+	}
+	return from, to, to < n
+}
+
+func hasAspects(r *fakeRecord, aspects []string) bool {
+	for _, a := range aspects {
+		if _, ok := r.Aspects[a]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// view returns a copy of 'r' only containing the requested aspects
+func view(r *fakeRecord, aspects []string, optAspects []string) *fakeRecord {
+	v := *r
+	v.Aspects = map[string]interface{}{}
+	for _, a := range append(aspects, optAspects...) {
+		if asp, ok := r.Aspects[a]; ok {
+			v.Aspects[a] = asp
+		}
+	}
+	return &v
+}
+
+/**** CREATE ****/
+
+func (reg *Registry) createRecord(s *store, w http.ResponseWriter, req *http.Request) {
+	var r fakeRecord
+	if !readJSON(w, req, &r) {
+		return
+	}
+	if r.ID == "" || r.Name == "" {
+		writeError(w, http.StatusBadRequest, "A record requires an 'id' and a 'name'.")
+		return
+	}
+	if _, ok := s.records[r.ID]; ok {
+		writeError(w, http.StatusBadRequest, "A record with the specified ID already exists.")
+		return
+	}
+	reg.insertRecord(s, &r)
+	writeJSON(w, http.StatusOK, &r)
+}
+
+func (reg *Registry) insertRecord(s *store, r *fakeRecord) {
+	aspects := r.Aspects
+	r.TenantID = s.tenantID
+	r.Aspects = map[string]interface{}{}
+	s.records[r.ID] = r
+	s.order = append(s.order, r.ID)
+	data := map[string]interface{}{"recordId": r.ID, "name": r.Name}
+	if r.SourceTag != "" {
+		data["sourceTag"] = r.SourceTag
+	}
+	reg.addEvent(s, r.ID, "CreateRecord", data)
+	for _, a := range sortedKeys(aspects) {
+		reg.putAspect(s, r, a, aspects[a])
+	}
+}
+
+/**** READ ****/
+
+func (reg *Registry) recordSummary(s *store, id string, w http.ResponseWriter, req *http.Request) {
+	r, ok := s.records[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No record exists with that ID.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       r.ID,
+		"name":     r.Name,
+		"aspects":  sortedKeys(r.Aspects),
+		"tenantId": r.TenantID,
+	})
+}
+
+func (reg *Registry) serveRecord(s *store, id string, w http.ResponseWriter, req *http.Request) {
+	r, ok := s.records[id]
+	switch req.Method {
+	case http.MethodGet:
+		aspects := queryList(req, "aspect")
+		if !ok || !hasAspects(r, aspects) {
+			writeError(w, http.StatusNotFound, "No record exists with that ID or it does not have the required aspects.")
+			return
+		}
+		writeJSON(w, http.StatusOK, view(r, aspects, queryList(req, "optionalAspect")))
+	case http.MethodPut:
+		var body struct {
+			fakeRecord
+			SourceTag *string `json:"sourceTag"` // absent keeps the current one
+		}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		nr := body.fakeRecord
+		if body.SourceTag != nil {
+			nr.SourceTag = *body.SourceTag
+		} else if ok {
+			nr.SourceTag = r.SourceTag
+		}
+		if nr.ID != id {
+			writeError(w, http.StatusBadRequest, "The provided ID does not match the record's ID.")
+			return
+		}
+		if !ok {
+			if nr.Name == "" {
+				writeError(w, http.StatusBadRequest, "A record requires a 'name'.")
+				return
+			}
+			reg.insertRecord(s, &nr)
+		} else {
+			reg.updateRecord(s, r, &nr)
+		}
+		writeJSON(w, http.StatusOK, &nr)
+	case http.MethodPatch:
+		if !ok {
+			writeError(w, http.StatusNotFound, "No record exists with that ID.")
+			return
+		}
+		var patched fakeRecord
+		patch, ok := applyPatch(w, req, r, &patched)
+		if !ok {
+			return
+		}
+		patched.ID = id
+		patched.TenantID = r.TenantID
+		if patched.Aspects == nil {
+			patched.Aspects = map[string]interface{}{}
+		}
+		s.records[id] = &patched
+		reg.addEvent(s, id, "PatchRecord", map[string]interface{}{"recordId": id, "patch": patch})
+		writeJSON(w, http.StatusOK, &patched)
+	case http.MethodDelete:
+		if ok {
+			delete(s.records, id)
+			for i, rid := range s.order {
+				if rid == id {
+					s.order = append(s.order[:i], s.order[i+1:]...)
+					break
+				}
+			}
+			reg.addEvent(s, id, "DeleteRecord", map[string]interface{}{"recordId": id})
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"deleted": ok})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// updateRecord changes name and source tag of 'r' and creates or updates all
+// aspects in 'nr'. As with Magda, aspects missing in 'nr' are kept, and so is
+// a source tag missing in the request.
+func (reg *Registry) updateRecord(s *store, r *fakeRecord, nr *fakeRecord) {
+	patch := []record.PatchOp{}
+	if nr.Name != "" && nr.Name != r.Name {
+		patch = append(patch, record.PatchReplaceOp("/name", nr.Name))
+		r.Name = nr.Name
+	}
+	if nr.SourceTag != r.SourceTag {
+		patch = append(patch, record.PatchReplaceOp("/sourceTag", nr.SourceTag))
+		r.SourceTag = nr.SourceTag
+	}
+	if len(patch) > 0 {
+		reg.addEvent(s, r.ID, "PatchRecord", map[string]interface{}{"recordId": r.ID, "patch": patch})
+	}
+	for _, a := range sortedKeys(nr.Aspects) {
+		reg.putAspect(s, r, a, nr.Aspects[a])
+	}
+	nr.Name = r.Name
+	nr.TenantID = r.TenantID
+}
+
+/**** ASPECTS ****/
+
+func (reg *Registry) serveRecordAspect(s *store, id string, aspect string, w http.ResponseWriter, req *http.Request) {
+	r, ok := s.records[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No record exists with that ID.")
+		return
+	}
+	asp, hasAspect := r.Aspects[aspect]
+	switch req.Method {
+	case http.MethodGet:
+		if !hasAspect {
+			writeError(w, http.StatusNotFound, "No record or aspect exists with the given IDs.")
+			return
+		}
+		writeJSON(w, http.StatusOK, asp)
+	case http.MethodPut:
+		var body interface{}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		reg.putAspect(s, r, aspect, body)
+		writeJSON(w, http.StatusOK, body)
+	case http.MethodPatch:
+		if !hasAspect {
+			asp = map[string]interface{}{}
+		}
+		var patched interface{}
+		patch, ok := applyPatch(w, req, asp, &patched)
+		if !ok {
+			return
+		}
+		r.Aspects[aspect] = patched
+		eventType := "PatchRecordAspect"
+		if !hasAspect {
+			eventType = "CreateRecordAspect"
+		}
+		reg.addEvent(s, id, eventType, map[string]interface{}{"recordId": id, "aspectId": aspect, "patch": patch})
+		writeJSON(w, http.StatusOK, patched)
+	case http.MethodDelete:
+		if hasAspect {
+			delete(r.Aspects, aspect)
+			reg.addEvent(s, id, "DeleteRecordAspect", map[string]interface{}{"recordId": id, "aspectId": aspect})
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"deleted": hasAspect})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// putAspect creates or replaces 'aspect' of 'r' and records the respective event
+func (reg *Registry) putAspect(s *store, r *fakeRecord, aspect string, body interface{}) {
+	var value interface{}
+	jsonCopy(body, &value)
+	old, ok := r.Aspects[aspect]
+	if ok && reflect.DeepEqual(old, value) {
+		return
+	}
+	r.Aspects[aspect] = value
+	if ok {
+		patch := []record.PatchOp{record.PatchReplaceOp("", value)}
+		reg.addEvent(s, r.ID, "PatchRecordAspect", map[string]interface{}{"recordId": r.ID, "aspectId": aspect, "patch": patch})
+	} else {
+		reg.addEvent(s, r.ID, "CreateRecordAspect", map[string]interface{}{"recordId": r.ID, "aspectId": aspect, "aspect": value})
+	}
+}
+
+/**** HISTORY ****/
+
+func (reg *Registry) addEvent(s *store, recordID string, eventType string, data map[string]interface{}) {
+	reg.lastEventID++
+	e := &event{
+		ID:        reg.lastEventID,
+		EventTime: reg.Now().UTC().Format(time.RFC3339Nano),
+		EventType: eventType,
+		Data:      data,
+		TenantID:  s.tenantID,
+	}
+	if r, ok := s.records[recordID]; ok {
+		var snapshot fakeRecord
+		jsonCopy(r, &snapshot)
+		e.snapshot = &snapshot
+	}
+	s.events[recordID] = append(s.events[recordID], e)
+}
+
+func (reg *Registry) listHistory(s *store, id string, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	events := s.events[id]
+	from, to, hasMore := pageBounds(req, len(events))
+	res := struct {
+		HasMore       bool     `json:"hasMore"`
+		NextPageToken string   `json:"nextPageToken,omitempty"`
+		Events        []*event `json:"events"`
+	}{HasMore: hasMore, Events: append([]*event{}, events[from:to]...)}
+	if hasMore {
+		res.NextPageToken = strconv.Itoa(to)
+	}
+	writeJSON(w, http.StatusOK, &res)
+}
+
+func (reg *Registry) historyEvent(s *store, id string, eventID string, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	for _, e := range s.events[id] {
+		if strconv.Itoa(e.ID) == eventID && e.snapshot != nil {
+			writeJSON(w, http.StatusOK, e.snapshot)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "No record exists with that ID at that event.")
+}
+
+/**** UTILS ****/
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
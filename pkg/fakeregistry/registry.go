@@ -0,0 +1,344 @@
+// Package fakeregistry implements an in-memory version of the Magda registry
+// and search endpoints used by this library. It is meant for tests and for
+// developing pipelines locally without a Magda cluster.
+//
+// Both the gateway paths ('/api/v0/registry/...') and the paths of the
+// registry itself ('/v0/...', see '--skip-gateway') are served. Every tenant,
+// as identified by the 'X-Magda-Tenant-Id' header, gets its own store.
+package fakeregistry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/record"
+)
+
+const (
+	gatewayRegistryPrefix = "/api/v0/registry/"
+	registryPrefix        = "/v0/"
+	searchPrefix          = "/api/v0/search/"
+
+	defaultLimit = 100
+)
+
+type Registry struct {
+	// Now returns the time used for events (defaults to time.Now)
+	Now func() time.Time
+
+	mu          sync.Mutex
+	tenants     map[string]*store
+	lastEventID int
+}
+
+type store struct {
+	tenantID int
+	records  map[string]*fakeRecord
+	order    []string // record IDs in order of creation
+	aspects  map[string]*aspectDefinition
+	hooks    map[string]map[string]interface{}
+	events   map[string][]*event // by record ID
+}
+
+type fakeRecord struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Aspects   map[string]interface{} `json:"aspects"`
+	SourceTag string                 `json:"sourceTag,omitempty"`
+	TenantID  int                    `json:"tenantId"`
+}
+
+type aspectDefinition struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	JSONSchema map[string]interface{} `json:"jsonSchema,omitempty"`
+}
+
+type event struct {
+	ID        int                    `json:"id"`
+	EventTime string                 `json:"eventTime"`
+	EventType string                 `json:"eventType"`
+	UserID    string                 `json:"userId,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+	TenantID  int                    `json:"tenantId"`
+	snapshot  *fakeRecord            // state of record after this event, nil if deleted
+}
+
+func New() *Registry {
+	return &Registry{Now: time.Now, tenants: map[string]*store{}}
+}
+
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	s := reg.store(req.Header.Get("X-Magda-Tenant-Id"))
+	p := req.URL.Path
+	switch {
+	case strings.HasPrefix(p, gatewayRegistryPrefix):
+		reg.serveRegistry(s, strings.TrimPrefix(p, gatewayRegistryPrefix), w, req)
+	case strings.HasPrefix(p, registryPrefix):
+		reg.serveRegistry(s, strings.TrimPrefix(p, registryPrefix), w, req)
+	case strings.HasPrefix(p, searchPrefix):
+		reg.serveSearch(s, strings.TrimPrefix(p, searchPrefix), w, req)
+	default:
+		writeError(w, http.StatusNotFound, "The requested resource could not be found.")
+	}
+}
+
+func (reg *Registry) store(tenant string) *store {
+	s, ok := reg.tenants[tenant]
+	if !ok {
+		tid, _ := strconv.Atoi(tenant)
+		s = &store{
+			tenantID: tid,
+			records:  map[string]*fakeRecord{},
+			aspects:  map[string]*aspectDefinition{},
+			hooks:    map[string]map[string]interface{}{},
+			events:   map[string][]*event{},
+		}
+		reg.tenants[tenant] = s
+	}
+	return s
+}
+
+func (reg *Registry) serveRegistry(s *store, path string, w http.ResponseWriter, req *http.Request) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	switch segs[0] {
+	case "records":
+		reg.serveRecords(s, segs[1:], w, req)
+	case "aspects":
+		reg.serveAspects(s, segs[1:], w, req)
+	case "hooks":
+		reg.serveHooks(s, segs[1:], w, req)
+	default:
+		writeError(w, http.StatusNotFound, "The requested resource could not be found.")
+	}
+}
+
+/**** ASPECT DEFINITIONS ****/
+
+func (reg *Registry) serveAspects(s *store, segs []string, w http.ResponseWriter, req *http.Request) {
+	if len(segs) == 0 || segs[0] == "" {
+		switch req.Method {
+		case http.MethodGet:
+			ids := make([]string, 0, len(s.aspects))
+			for id := range s.aspects {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			res := make([]*aspectDefinition, len(ids))
+			for i, id := range ids {
+				res[i] = s.aspects[id]
+			}
+			writeJSON(w, http.StatusOK, res)
+		case http.MethodPost:
+			var a aspectDefinition
+			if !readJSON(w, req, &a) {
+				return
+			}
+			if a.ID == "" {
+				writeError(w, http.StatusBadRequest, "Missing aspect 'id'")
+				return
+			}
+			if _, ok := s.aspects[a.ID]; ok {
+				writeError(w, http.StatusBadRequest, "An aspect with the specified ID already exists.")
+				return
+			}
+			s.aspects[a.ID] = &a
+			writeJSON(w, http.StatusOK, &a)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	id := segs[0]
+	switch req.Method {
+	case http.MethodGet:
+		if a, ok := s.aspects[id]; ok {
+			writeJSON(w, http.StatusOK, a)
+		} else {
+			writeError(w, http.StatusNotFound, "No aspect exists with that ID.")
+		}
+	case http.MethodPut:
+		var a aspectDefinition
+		if !readJSON(w, req, &a) {
+			return
+		}
+		if a.ID != id {
+			writeError(w, http.StatusBadRequest, "The provided ID does not match the aspect's ID.")
+			return
+		}
+		s.aspects[id] = &a
+		writeJSON(w, http.StatusOK, &a)
+	case http.MethodPatch:
+		a, ok := s.aspects[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "No aspect exists with that ID.")
+			return
+		}
+		var patched aspectDefinition
+		if _, ok := applyPatch(w, req, a, &patched); !ok {
+			return
+		}
+		patched.ID = id
+		s.aspects[id] = &patched
+		writeJSON(w, http.StatusOK, &patched)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+/**** HOOKS ****/
+
+func (reg *Registry) serveHooks(s *store, segs []string, w http.ResponseWriter, req *http.Request) {
+	if len(segs) == 0 || segs[0] == "" {
+		switch req.Method {
+		case http.MethodGet:
+			ids := make([]string, 0, len(s.hooks))
+			for id := range s.hooks {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			res := make([]interface{}, len(ids))
+			for i, id := range ids {
+				res[i] = s.hooks[id]
+			}
+			writeJSON(w, http.StatusOK, res)
+		case http.MethodPost:
+			var h map[string]interface{}
+			if !readJSON(w, req, &h) {
+				return
+			}
+			id, _ := h["id"].(string)
+			if id == "" {
+				writeError(w, http.StatusBadRequest, "Missing hook 'id'")
+				return
+			}
+			if _, ok := s.hooks[id]; ok {
+				writeError(w, http.StatusBadRequest, "A web hook with the specified ID already exists.")
+				return
+			}
+			s.hooks[id] = h
+			writeJSON(w, http.StatusOK, h)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	id := segs[0]
+	switch req.Method {
+	case http.MethodGet:
+		if h, ok := s.hooks[id]; ok {
+			writeJSON(w, http.StatusOK, h)
+		} else {
+			writeError(w, http.StatusNotFound, "No web hook exists with that ID.")
+		}
+	case http.MethodPut:
+		var h map[string]interface{}
+		if !readJSON(w, req, &h) {
+			return
+		}
+		h["id"] = id
+		s.hooks[id] = h
+		writeJSON(w, http.StatusOK, h)
+	case http.MethodDelete:
+		_, ok := s.hooks[id]
+		delete(s.hooks, id)
+		writeJSON(w, http.StatusOK, map[string]bool{"deleted": ok})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+/**** UTILS ****/
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, msg string) {
+	writeJSON(w, statusCode, map[string]string{"message": msg})
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The request content was malformed: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// applyPatch applies the JSON Patch in the request body to 'doc', unmarshals
+// the result into 'res', and returns the applied patch. Returns false, if an
+// error has already been reported to the client.
+func applyPatch(w http.ResponseWriter, req *http.Request, doc interface{}, res interface{}) ([]record.PatchOp, bool) {
+	var ops []interface{}
+	if !readJSON(w, req, &ops) {
+		return nil, false
+	}
+	var generic interface{}
+	if err := jsonCopy(doc, &generic); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	patchOps := make([]record.PatchOp, len(ops))
+	for i, op := range ops {
+		patchOps[i] = op
+	}
+	patched, err := record.ApplyPatch(generic, patchOps)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if err := jsonCopy(patched, res); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return patchOps, true
+}
+
+func jsonCopy(from interface{}, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+func queryInt(req *http.Request, name string, def int) int {
+	if v := req.URL.Query().Get(name); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 {
+			return i
+		}
+	}
+	return def
+}
+
+// queryList returns all values of query parameter 'name', splitting comma separated ones
+func queryList(req *http.Request, name string) []string {
+	res := []string{}
+	for _, v := range req.URL.Query()[name] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
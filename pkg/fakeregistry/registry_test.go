@@ -0,0 +1,154 @@
+package fakeregistry
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/minion"
+	"github.com/maxott/magda-cli/pkg/record"
+	"github.com/maxott/magda-cli/pkg/schema"
+	"github.com/maxott/magda-cli/pkg/search"
+	log "go.uber.org/zap"
+)
+
+func createOrder(t *testing.T, adpt *adapter.Adapter, id string, status string, value float64) {
+	r := record.CreateRequest{Id: id, Name: "Order " + id, Aspects: record.Aspects{
+		"cse-order": record.Aspect{"status": status, "parameters": []interface{}{map[string]interface{}{"value": value}}},
+	}}
+	if _, err := record.CreateRaw(context.Background(), &r, adpt, log.NewNop()); err != nil {
+		t.Fatalf("creating record '%s' - %v", id, err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	for _, skipGateway := range []bool{false, true} {
+		adpt := New().TestAdapter(t, adapter.ConnectionCtxt{SkipGateway: skipGateway})
+		ctxt := context.Background()
+		logger := log.NewNop()
+
+		createOrder(t, adpt, "o1", "pending", 5)
+		createOrder(t, adpt, "o2", "done", 10)
+		createOrder(t, adpt, "o3", "pending", 20)
+
+		q := func(s string) record.QueryTerm { return record.NewQueryTermS(s) }
+		res, err := record.List(ctxt, &record.ListRequest{
+			Aspects: "cse-order", Offset: -1, Limit: -1,
+			AndQuery: []record.QueryTerm{
+				{Path: "cse-order.status", Op: record.Equal, Value: "pending"},
+				{Path: "cse-order.parameters.0.value", Op: record.GreaterEqualThan, Value: 10},
+			},
+		}, adpt, logger)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		if len(res.Records) != 1 || res.Records[0].ID != "o3" || res.Records[0].Aspects["cse-order"] == nil {
+			t.Fatalf("unexpected query result %+v", res)
+		}
+
+		res, _ = record.List(ctxt, &record.ListRequest{Offset: -1, Limit: 2, OrQuery: []record.QueryTerm{
+			q("cse-order.status%3Adone"), {Path: "cse-order.status", Op: record.MatchPattern, Value: "pend%"},
+		}}, adpt, logger)
+		if len(res.Records) != 2 || !res.HasMore || res.NextPageToken == "" {
+			t.Fatalf("unexpected first page %+v", res)
+		}
+		res, _ = record.List(ctxt, &record.ListRequest{Offset: -1, Limit: 2, PageToken: res.NextPageToken}, adpt, logger)
+		if len(res.Records) != 1 || res.HasMore || res.Records[0].ID != "o3" {
+			t.Fatalf("unexpected second page %+v", res)
+		}
+
+		patch := &record.PatchAspectRequest{Id: "o1", Aspect: "cse-order", Patch: []record.PatchOp{
+			record.PatchReplaceOp("/status", "done"),
+		}}
+		if _, err := record.PatchAspectRaw(ctxt, patch, adpt, logger); err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		pld, err := record.ReadRaw(ctxt, &record.ReadRequest{Id: "o1", Aspect: "cse-order"}, adpt, logger)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		if obj, _ := pld.AsObject(); obj["status"] != "done" {
+			t.Fatalf("patch not applied - %s", pld.AsBytes())
+		}
+
+		pld, err = record.HistoryRaw(ctxt, &record.HistoryRequest{Id: "o1", Offset: -1, Limit: -1}, adpt, logger)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		var history struct {
+			Events []struct {
+				EventType string `json:"eventType"`
+			} `json:"events"`
+		}
+		json.Unmarshal(pld.AsBytes(), &history)
+		types := []string{}
+		for _, e := range history.Events {
+			types = append(types, e.EventType)
+		}
+		if strings.Join(types, ",") != "CreateRecord,CreateRecordAspect,PatchRecordAspect" {
+			t.Fatalf("unexpected events %v", types)
+		}
+
+		if _, err := record.DeleteRaw(ctxt, &record.DeleteRequest{Id: "o2"}, adpt, logger); err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		if _, err := record.ReadRaw(ctxt, &record.ReadRequest{Id: "o2"}, adpt, logger); err == nil {
+			t.Fatalf("expected error for deleted record")
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	reg := New()
+	adpt1 := reg.TestAdapter(t, adapter.ConnectionCtxt{TenantID: "1"})
+	createOrder(t, adpt1, "o1", "pending", 5)
+
+	adpt2 := reg.TestAdapter(t, adapter.ConnectionCtxt{TenantID: "2"})
+	res, err := record.List(context.Background(), &record.ListRequest{Offset: -1, Limit: -1}, adpt2, log.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(res.Records) != 0 {
+		t.Fatalf("expected no records for other tenant, but got %+v", res.Records)
+	}
+}
+
+func TestSchemaHooksSearch(t *testing.T) {
+	adpt := New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	s := schema.CreateRequest{Id: "cse-order", Name: "Order", Schema: map[string]interface{}{"type": "object"}}
+	if _, err := schema.CreateRaw(ctxt, &s, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := schema.UpdateRaw(ctxt, &schema.UpdateRequest{Id: "cse-order", Schema: map[string]interface{}{}}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if pld, err := schema.ListRaw(ctxt, &schema.ListRequest{}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	} else if arr, _ := pld.AsArray(); len(arr) != 1 {
+		t.Fatalf("unexpected aspect list %s", pld.AsBytes())
+	}
+
+	if _, err := minion.CreateRaw(ctxt, &minion.CreateRequest{Id: "m1", Url: "http://foo"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := minion.DeleteRaw(ctxt, &minion.DeleteRequest{Id: "m1"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	r := record.CreateRequest{Id: "ds1", Name: "DS", Aspects: record.Aspects{
+		"dcat-dataset-strings": record.Aspect{"title": "Rainfall in Sydney", "publisher": "BOM"},
+	}}
+	record.CreateRaw(ctxt, &r, adpt, logger)
+	res, err := search.Dataset(ctxt, &search.DatasetRequest{Query: "rainfall", Offset: -1, Limit: -1, Publisher: "bom"}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if res.HitCount != 1 || res.DataSets[0].Title != "Rainfall in Sydney" {
+		t.Fatalf("unexpected search result %+v", res)
+	}
+}
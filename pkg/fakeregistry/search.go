@@ -0,0 +1,60 @@
+package fakeregistry
+
+import (
+	"net/http"
+	"strings"
+)
+
+const datasetAspect = "dcat-dataset-strings"
+
+// serveSearch provides a very simple version of Magda's dataset search. Every
+// record with a 'dcat-dataset-strings' aspect is a dataset, and it matches a
+// query if all query words can be found in its title, description or keywords.
+func (reg *Registry) serveSearch(s *store, path string, w http.ResponseWriter, req *http.Request) {
+	if strings.Trim(path, "/") != "datasets" || req.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "The requested resource could not be found.")
+		return
+	}
+	words := strings.Fields(strings.ToLower(req.URL.Query().Get("query")))
+	publisher := req.URL.Query().Get("publisher")
+
+	hits := []map[string]interface{}{}
+	for _, id := range s.order {
+		asp, ok := s.records[id].Aspects[datasetAspect].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if publisher != "" && !strings.EqualFold(asText(asp["publisher"]), publisher) {
+			continue
+		}
+		if !matchWords(asp, words) {
+			continue
+		}
+		ds := map[string]interface{}{"identifier": id}
+		for k, v := range asp {
+			ds[k] = v
+		}
+		hits = append(hits, ds)
+	}
+	from, to, _ := pageBounds(req, len(hits))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hitCount": len(hits),
+		"dataSets": hits[from:to],
+	})
+}
+
+func matchWords(asp map[string]interface{}, words []string) bool {
+	text := []string{asText(asp["title"]), asText(asp["description"])}
+	if kws, ok := asp["keywords"].([]interface{}); ok {
+		for _, kw := range kws {
+			text = append(text, asText(kw))
+		}
+	}
+	all := strings.ToLower(strings.Join(text, " "))
+	for _, w := range words {
+		if w != "*" && !strings.Contains(all, w) {
+			return false
+		}
+	}
+	return true
+}
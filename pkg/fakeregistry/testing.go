@@ -0,0 +1,21 @@
+package fakeregistry
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+)
+
+// TestAdapter serves the registry on a local test server, which is closed at
+// the end of test 't', and returns an adapter talking to it. The host of
+// 'connCtxt' is set to the test server, all other settings, like the tenant,
+// are used as given.
+func (reg *Registry) TestAdapter(t testing.TB, connCtxt adapter.ConnectionCtxt) *adapter.Adapter {
+	srv := httptest.NewServer(reg)
+	t.Cleanup(srv.Close)
+	connCtxt.Host = strings.TrimPrefix(srv.URL, "http://")
+	a := adapter.RestAdapter(connCtxt)
+	return &a
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/maxott/magda-cli/pkg/adapter"
 	log "go.uber.org/zap"
@@ -14,6 +15,37 @@ import (
 type ListRequest struct {
 }
 
+type WebHook struct {
+	ID                   string        `json:"id"`
+	Name                 string        `json:"name"`
+	URL                  string        `json:"url"`
+	Active               bool          `json:"active"`
+	Enabled              bool          `json:"enabled"`
+	EventTypes           []EventType   `json:"eventTypes"`
+	Config               WebHookConfig `json:"config"`
+	LastEvent            *int64        `json:"lastEvent,omitempty"`
+	IsWaitingForResponse *bool         `json:"isWaitingForResponse,omitempty"`
+	LastRetryTime        *time.Time    `json:"lastRetryTime,omitempty"`
+	RetryCount           int           `json:"retryCount"`
+	IsRunning            *bool         `json:"isRunning,omitempty"`
+	IsProcessing         *bool         `json:"isProcessing,omitempty"`
+}
+
+type WebHookConfig struct {
+	Aspects                  []string `json:"aspects"`
+	OptionalAspects          []string `json:"optionalAspects"`
+	IncludeEvents            bool     `json:"includeEvents"`
+	IncludeRecords           bool     `json:"includeRecords"`
+	IncludeAspectDefinitions bool     `json:"includeAspectDefinitions"`
+	Dereference              bool     `json:"dereference"`
+}
+
+func List(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) ([]WebHook, error) {
+	res := []WebHook{}
+	err := adapter.GetAs(ctxt, *adpt, minionPath(nil, adpt), &res, logger)
+	return res, err
+}
+
 func ListRaw(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
 	path := minionPath(nil, adpt)
 	return (*adpt).Get(ctxt, path, logger)
@@ -79,27 +111,18 @@ type CreateRequest struct {
 }
 
 type createPayload struct {
-	Id         string       `json:"id"`
-	Name       string       `json:"name"`
-	Url        string       `json:"url"`
-	Active     bool         `json:"active"`
-	Enabled    bool         `json:"enabled"`
-	EventTypes []EventType  `json:"eventTypes"`
-	Config     createConfig `json:"config"`
-	RetryCount int          `json:"retryCount"`
-}
-
-type createConfig struct {
-	Aspects                  []string `json:"aspects"`
-	OptionalAspects          []string `json:"optionalAspects"`
-	IncludeEvents            bool     `json:"includeEvents"`
-	IncludeRecords           bool     `json:"includeRecords"`
-	IncludeAspectDefinitions bool     `json:"includeAspectDefinitions"`
-	Dereference              bool     `json:"dereference"`
+	Id         string        `json:"id"`
+	Name       string        `json:"name"`
+	Url        string        `json:"url"`
+	Active     bool          `json:"active"`
+	Enabled    bool          `json:"enabled"`
+	EventTypes []EventType   `json:"eventTypes"`
+	Config     WebHookConfig `json:"config"`
+	RetryCount int           `json:"retryCount"`
 }
 
 func CreateRaw(ctxt context.Context, cmd *CreateRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
-	config := createConfig{
+	config := WebHookConfig{
 		Aspects:                  cmd.Aspects,
 		OptionalAspects:          cmd.OptionalAspects,
 		IncludeEvents:            false,
@@ -189,10 +212,7 @@ func DeleteRaw(ctxt context.Context, cmd *DeleteRequest, adpt *adapter.Adapter,
 /**** Utils ****/
 
 func minionPath(id *string, adpt *adapter.Adapter) string {
-	path := "/api/v0/registry/hooks"
-	if (*adpt).SkipGateway() {
-		path = "/v0/hooks"
-	}
+	path := adapter.BasePath(*adpt, adapter.HooksAPI)
 	if id != nil {
 		path = path + "/" + *id
 	}
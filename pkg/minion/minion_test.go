@@ -0,0 +1,30 @@
+package minion
+
+import (
+	"context"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	log "go.uber.org/zap"
+)
+
+func TestTypedResults(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	if _, err := CreateRaw(ctxt, &CreateRequest{Id: "m1", Url: "http://foo", Aspects: []string{"cse-order"}}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	hooks, err := List(ctxt, &ListRequest{}, adpt, logger)
+	if err != nil || len(hooks) != 1 || hooks[0].URL != "http://foo" || hooks[0].Config.Aspects[0] != "cse-order" {
+		t.Fatalf("unexpected web hooks %+v - %v", hooks, err)
+	}
+	if _, err := DeleteRaw(ctxt, &DeleteRequest{Id: "m1"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if hooks, err := List(ctxt, &ListRequest{}, adpt, logger); err != nil || len(hooks) != 0 {
+		t.Fatalf("unexpected web hooks %+v - %v", hooks, err)
+	}
+}
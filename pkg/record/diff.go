@@ -0,0 +1,113 @@
+package record
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**** DIFF ****/
+
+// Change describes a single difference between two JSON documents
+type Change struct {
+	Op       string // "add", "remove" or "replace"
+	Path     string // JSON pointer
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// PatchOp returns the patch operation performing this change
+func (c *Change) PatchOp() PatchOp {
+	switch c.Op {
+	case "add":
+		return PatchAddOp(c.Path, c.NewValue)
+	case "remove":
+		return PatchRemoveOp(c.Path)
+	default:
+		return PatchReplaceOp(c.Path, c.NewValue)
+	}
+}
+
+// Diff returns the changes needed to turn 'from' into 'to'. Both are expected
+// to be generic JSON as returned by 'json.Unmarshal' into an 'interface{}'.
+// Objects are compared member by member and arrays element by element, with
+// elements added or removed at the end.
+func Diff(from interface{}, to interface{}) []Change {
+	return diff("", from, to, []Change{})
+}
+
+// DiffPatch returns the RFC 6902 patch turning 'from' into 'to'
+func DiffPatch(from interface{}, to interface{}) []PatchOp {
+	changes := Diff(from, to)
+	ops := make([]PatchOp, len(changes))
+	for i := range changes {
+		ops[i] = changes[i].PatchOp()
+	}
+	return ops
+}
+
+func diff(path string, from interface{}, to interface{}, changes []Change) []Change {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			return diffObject(path, f, t, changes)
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			return diffArray(path, f, t, changes)
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		changes = append(changes, Change{Op: "replace", Path: path, OldValue: from, NewValue: to})
+	}
+	return changes
+}
+
+func diffObject(path string, from map[string]interface{}, to map[string]interface{}, changes []Change) []Change {
+	for _, k := range sortedKeys(from) {
+		p := path + "/" + escapePointer(k)
+		if tv, ok := to[k]; ok {
+			changes = diff(p, from[k], tv, changes)
+		} else {
+			changes = append(changes, Change{Op: "remove", Path: p, OldValue: from[k]})
+		}
+	}
+	for _, k := range sortedKeys(to) {
+		if _, ok := from[k]; !ok {
+			changes = append(changes, Change{Op: "add", Path: path + "/" + escapePointer(k), NewValue: to[k]})
+		}
+	}
+	return changes
+}
+
+func diffArray(path string, from []interface{}, to []interface{}, changes []Change) []Change {
+	n := len(from)
+	if len(to) < n {
+		n = len(to)
+	}
+	for i := 0; i < n; i++ {
+		changes = diff(path+"/"+strconv.Itoa(i), from[i], to[i], changes)
+	}
+	// remove from the end to keep the indices of the remaining ones valid
+	for i := len(from) - 1; i >= n; i-- {
+		changes = append(changes, Change{Op: "remove", Path: path + "/" + strconv.Itoa(i), OldValue: from[i]})
+	}
+	for i := n; i < len(to); i++ {
+		changes = append(changes, Change{Op: "add", Path: path + "/" + strconv.Itoa(i), NewValue: to[i]})
+	}
+	return changes
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,42 @@
+package record
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := parseJSON(t, `{"status": "pending", "a/b": 1, "tags": ["x", "y", "z"], "params": [{"v": 1}], "old": true}`)
+	to := parseJSON(t, `{"status": "done", "a/b": 1, "tags": ["x"], "params": [{"v": 2}, {"v": 3}], "new": null}`)
+
+	changes := Diff(from, to)
+	paths := []string{}
+	for _, c := range changes {
+		paths = append(paths, c.Op+" "+c.Path)
+	}
+	expected := []string{
+		"remove /old", "replace /params/0/v", "add /params/1", "replace /status",
+		"remove /tags/2", "remove /tags/1", "add /new",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("unexpected changes %v", paths)
+	}
+
+	res, err := ApplyPatch(from, DiffPatch(from, to))
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if !reflect.DeepEqual(res, to) {
+		t.Errorf("patch doesn't reproduce target, got %v", res)
+	}
+
+	if c := Diff(from, from); len(c) != 0 {
+		t.Errorf("expected no changes, but got %v", c)
+	}
+	if c := Diff(parseJSON(t, `{"~a": 1}`), parseJSON(t, `[]`)); len(c) != 1 || c[0].Path != "" {
+		t.Errorf("expected replacement of root, but got %v", c)
+	}
+	if c := Diff(parseJSON(t, `{"~a": 1}`), parseJSON(t, `{}`)); c[0].Path != "/~0a" {
+		t.Errorf("expected escaped path, but got %v", c)
+	}
+}
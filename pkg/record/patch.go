@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/maxott/magda-cli/pkg/adapter"
 	log "go.uber.org/zap"
@@ -30,10 +34,32 @@ func PatchAspectRaw(ctxt context.Context, cmd *PatchAspectRequest, adpt *adapter
 	return (*adpt).Patch(ctxt, path, bytes.NewReader(body), logger)
 }
 
+type PatchRecordRequest struct {
+	Id    string
+	Patch []PatchOp
+}
+
+// PatchRecordRaw applies 'cmd.Patch' to the record itself, e.g. to change
+// '/name' or '/sourceTag'
+func PatchRecordRaw(ctxt context.Context, cmd *PatchRecordRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
+	path := recordPath(&cmd.Id, adpt)
+	body, err := json.MarshalIndent(cmd.Patch, "", "  ")
+	if err != nil {
+		logger.Error("marshalling body", log.Error(err))
+		return nil, err
+	}
+	return (*adpt).Patch(ctxt, path, bytes.NewReader(body), logger)
+}
+
 type patch struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
-	Value interface{} `json:"value,omitempty"`
+	Value interface{} `json:"value"` // may legitimately be 'null'
+}
+
+type patch1 struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
 }
 
 // { "op": "add", "path": "/biscuits/1", "value": { "name": "Ginger Nut" } }
@@ -47,7 +73,7 @@ func PatchAddOp(path string, value interface{}) PatchOp {
 
 // { "op": "remove", "path": "/biscuits" }
 func PatchRemoveOp(path string) PatchOp {
-	return patch{
+	return patch1{
 		Op:   "remove",
 		Path: path,
 	}
@@ -62,6 +88,30 @@ func PatchReplaceOp(path string, value interface{}) PatchOp {
 	}
 }
 
+// PatchSetOp returns a 'replace' operation if 'path' already exists in
+// 'doc', and an 'add' operation otherwise. Unlike 'add', it overwrites an
+// existing array element instead of inserting before it.
+func PatchSetOp(doc interface{}, path string, value interface{}) PatchOp {
+	if tokens, err := parsePointer(path); err == nil && len(tokens) > 0 {
+		if _, err := pointerGet(doc, tokens); err == nil {
+			return PatchReplaceOp(path, value)
+		}
+	}
+	return PatchAddOp(path, value)
+}
+
+// { "op": "test", "path": "/status", "value": "pending" }
+//
+// Fails the entire patch if the value at 'path' isn't equal to 'value'.
+// Use it ahead of other operations to guard against concurrent updates.
+func PatchTestOp(path string, value interface{}) PatchOp {
+	return patch{
+		Op:    "test",
+		Path:  path,
+		Value: value,
+	}
+}
+
 type patch2 struct {
 	Op   string `json:"op"`
 	From string `json:"from"`
@@ -85,3 +135,291 @@ func PatchMoveOp(from string, path string) PatchOp {
 		Path: path,
 	}
 }
+
+// ParsePatch parses a JSON array of RFC 6902 operations and verifies that
+// they are well formed.
+func ParsePatch(data []byte) ([]PatchOp, error) {
+	var pops []patchOp
+	if err := json.Unmarshal(data, &pops); err != nil {
+		return nil, fmt.Errorf("patch needs to be an array of operations - %s", err)
+	}
+	ops := make([]PatchOp, len(pops))
+	for i, op := range pops {
+		if err := op.verify(); err != nil {
+			return nil, fmt.Errorf("patch operation %d - %s", i, err)
+		}
+		switch op.Op {
+		case "remove":
+			ops[i] = patch1{op.Op, op.Path}
+		case "copy", "move":
+			ops[i] = patch2{op.Op, op.From, op.Path}
+		default:
+			ops[i] = patch{op.Op, op.Path, op.Value}
+		}
+	}
+	return ops, nil
+}
+
+/**** APPLY ****/
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ApplyPatch applies the RFC 6902 operations in 'ops' to 'doc' and returns the
+// result. 'doc' is expected to be generic JSON as returned by 'json.Unmarshal'
+// into an 'interface{}' and is not modified.
+func ApplyPatch(doc interface{}, ops []PatchOp) (interface{}, error) {
+	var pops []patchOp
+	if err := jsonCopy(ops, &pops); err != nil {
+		return nil, err
+	}
+	var res interface{}
+	if err := jsonCopy(doc, &res); err != nil {
+		return nil, err
+	}
+	for i, op := range pops {
+		var err error
+		if res, err = applyOp(res, &op); err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s '%s') - %s", i, op.Op, op.Path, err)
+		}
+	}
+	return res, nil
+}
+
+func (op *patchOp) verify() error {
+	if _, err := parsePointer(op.Path); err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("'%s' is missing 'value'", op.Op)
+		}
+	case "copy", "move":
+		if _, err := parsePointer(op.From); err != nil {
+			return fmt.Errorf("'%s' has illegal 'from' - %s", op.Op, err)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+	return nil
+}
+
+func applyOp(doc interface{}, op *patchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := func() (interface{}, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing 'value'")
+		}
+		var v interface{}
+		err := json.Unmarshal(op.Value, &v)
+		return v, err
+	}
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			var c interface{}
+			if err = jsonCopy(v, &c); err != nil {
+				return nil, err
+			}
+			v = c
+		}
+		return pointerAdd(doc, path, v)
+	case "test":
+		exp, err := value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, exp) {
+			return nil, fmt.Errorf("test failed, value is '%v'", v)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("path '%s' needs to start with '/'", p)
+	}
+	toks := strings.Split(p[1:], "/")
+	for i, t := range toks {
+		toks[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return toks, nil
+}
+
+func arrayIndex(tok string, max int) (int, error) {
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx < 0 || idx > max || (tok != "0" && strings.HasPrefix(tok, "0")) {
+		return 0, fmt.Errorf("illegal array index '%s'", tok)
+	}
+	return idx, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, tok := range path {
+		switch n := doc.(type) {
+		case map[string]interface{}:
+			v, ok := n[tok]
+			if !ok {
+				return nil, fmt.Errorf("member '%s' not found", tok)
+			}
+			doc = v
+		case []interface{}:
+			idx, err := arrayIndex(tok, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[idx]
+		default:
+			return nil, fmt.Errorf("can't resolve '%s' in a scalar value", tok)
+		}
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	tok := path[0]
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			n[tok] = value
+			return n, nil
+		}
+		child, ok := n[tok]
+		if !ok {
+			return nil, fmt.Errorf("member '%s' not found", tok)
+		}
+		c, err := pointerAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[tok] = c
+		return n, nil
+	case []interface{}:
+		if len(path) == 1 {
+			if tok == "-" {
+				return append(n, value), nil
+			}
+			idx, err := arrayIndex(tok, len(n))
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+			return n, nil
+		}
+		idx, err := arrayIndex(tok, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		c, err := pointerAdd(n[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = c
+		return n, nil
+	default:
+		return nil, fmt.Errorf("can't add '%s' to a scalar value", tok)
+	}
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	tok := path[0]
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		child, ok := n[tok]
+		if !ok {
+			return nil, fmt.Errorf("member '%s' not found", tok)
+		}
+		if len(path) == 1 {
+			delete(n, tok)
+			return n, nil
+		}
+		c, err := pointerRemove(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[tok] = c
+		return n, nil
+	case []interface{}:
+		idx, err := arrayIndex(tok, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(n[:idx], n[idx+1:]...), nil
+		}
+		c, err := pointerRemove(n[idx], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = c
+		return n, nil
+	default:
+		return nil, fmt.Errorf("can't remove '%s' from a scalar value", tok)
+	}
+}
+
+// jsonCopy deep copies 'from' into 'to' by marshalling it to JSON and back
+func jsonCopy(from interface{}, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
@@ -0,0 +1,129 @@
+package record
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func parseJSON(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("illegal test JSON '%s' - %v", s, err)
+	}
+	return v
+}
+
+func TestApplyPatch(t *testing.T) {
+	doc := parseJSON(t, `{"biscuits": [{"name": "Digestive"}, {"name": "Choco Leibniz"}], "a/b": 1}`)
+	ops := []PatchOp{
+		PatchAddOp("/biscuits/1", map[string]interface{}{"name": "Ginger Nut"}),
+		PatchReplaceOp("/biscuits/0/name", "Chocolate Digestive"),
+		PatchRemoveOp("/a~1b"),
+		PatchCopyOp("/biscuits/0", "/best_biscuit"),
+		PatchMoveOp("/biscuits/2", "/worst_biscuit"),
+		PatchAddOp("/biscuits/-", "Oreo"),
+	}
+	res, err := ApplyPatch(doc, ops)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	exp := parseJSON(t, `{
+		"biscuits": [{"name": "Chocolate Digestive"}, {"name": "Ginger Nut"}, "Oreo"],
+		"best_biscuit": {"name": "Chocolate Digestive"},
+		"worst_biscuit": {"name": "Choco Leibniz"}
+	}`)
+	if !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected %v, but got %v", exp, res)
+	}
+	if _, ok := doc.(map[string]interface{})["a/b"]; !ok {
+		t.Fatalf("original document has been modified")
+	}
+}
+
+func TestPatchSetOp(t *testing.T) {
+	doc := parseJSON(t, `{"status": "pending", "list": ["a", "b"]}`)
+	cases := []struct {
+		path string
+		op   string
+	}{
+		{"/status", "replace"},
+		{"/list/1", "replace"},
+		{"/list/2", "add"},
+		{"/list/-", "add"},
+		{"/error", "add"},
+		{"", "add"},
+	}
+	for _, c := range cases {
+		if op := PatchSetOp(doc, c.path, "x").(patch).Op; op != c.op {
+			t.Errorf("%s: expected '%s', but got '%s'", c.path, c.op, op)
+		}
+	}
+	res, err := ApplyPatch(doc, []PatchOp{PatchSetOp(doc, "/list/0", "c")})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if exp := parseJSON(t, `{"status": "pending", "list": ["c", "b"]}`); !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected %v, but got %v", exp, res)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	doc := parseJSON(t, `{"a": [1, 2], "s": "x"}`)
+	for _, op := range []PatchOp{
+		PatchRemoveOp("/b"),
+		PatchReplaceOp("/a/2", 3),
+		PatchAddOp("/a/01", 3),
+		PatchAddOp("/s/x", 3),
+		PatchAddOp("b/c", 3),
+		PatchMoveOp("/x", "/y"),
+	} {
+		if _, err := ApplyPatch(doc, []PatchOp{op}); err == nil {
+			t.Errorf("expected error for %v", op)
+		}
+	}
+}
+
+func TestApplyPatchRoot(t *testing.T) {
+	res, err := ApplyPatch(parseJSON(t, `{"a": 1}`), []PatchOp{PatchReplaceOp("", map[string]interface{}{"b": 2})})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if !reflect.DeepEqual(res, parseJSON(t, `{"b": 2}`)) {
+		t.Fatalf("unexpected result %v", res)
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	ops, err := ParsePatch([]byte(`[
+		{"op": "test", "path": "/status", "value": null},
+		{"op": "add", "path": "/status", "value": "done"},
+		{"op": "remove", "path": "/count"},
+		{"op": "move", "from": "/a", "path": "/b"}
+	]`))
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	res, err := ApplyPatch(parseJSON(t, `{"status": null, "count": 1, "a": 2}`), ops)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if !reflect.DeepEqual(res, parseJSON(t, `{"status": "done", "b": 2}`)) {
+		t.Errorf("unexpected result %v", res)
+	}
+	if b, _ := json.Marshal(PatchTestOp("/x", nil)); string(b) != `{"op":"test","path":"/x","value":null}` {
+		t.Errorf("unexpected test operation %s", b)
+	}
+
+	for _, p := range []string{
+		`{"op": "add", "path": "/a", "value": 1}`,
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "copy", "path": "/a", "from": "a"}]`,
+		`[{"op": "remove", "path": "a"}]`,
+		`[{"op": "bogus", "path": "/a"}]`,
+	} {
+		if _, err := ParsePatch([]byte(p)); err == nil {
+			t.Errorf("expected error for %s", p)
+		}
+	}
+}
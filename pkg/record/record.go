@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/maxott/magda-cli/pkg/adapter"
@@ -17,12 +21,13 @@ import (
 /**** LIST ****/
 
 type ListRequest struct {
-	Aspects   string
-	AndQuery  []QueryTerm
-	OrQuery   []QueryTerm
-	Offset    int
-	Limit     int
-	PageToken string
+	Aspects         string
+	OptionalAspects string // included if present, but not required for a record to match
+	AndQuery        []QueryTerm
+	OrQuery         []QueryTerm
+	Offset          int
+	Limit           int
+	PageToken       string
 }
 
 type QueryTerm struct {
@@ -117,15 +122,17 @@ func toQueryOp(s string) QueryOp {
 }
 
 type ListResult struct {
-	HasMore       bool   `json:"hasMore"`
-	NextPageToken string `json:"nextPageToken"`
-	Records       []struct {
-		Aspects   map[string]interface{} `json:"aspects"`
-		ID        string                 `json:"id"`
-		Name      string                 `json:"name"`
-		SourceTag string                 `json:"sourceTag"`
-		TenantID  int                    `json:"tenantId"`
-	} `json:"records"`
+	HasMore       bool     `json:"hasMore"`
+	NextPageToken string   `json:"nextPageToken"`
+	Records       []Record `json:"records"`
+}
+
+type Record struct {
+	Aspects   map[string]interface{} `json:"aspects"`
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	SourceTag string                 `json:"sourceTag"`
+	TenantID  int                    `json:"tenantId"`
 }
 
 func List(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) (ListResult, error) {
@@ -139,12 +146,151 @@ func List(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger
 }
 
 func ListRaw(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
+	return (*adpt).Get(ctxt, listPath(cmd, adpt), logger)
+}
+
+// ListRawStream is the streaming version of ListRaw. The returned payload needs to be closed.
+func ListRawStream(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.StreamPayload, error) {
+	return adapter.GetStream(ctxt, *adpt, listPath(cmd, adpt), logger)
+}
+
+// ListStream calls 'onRecord' for each record in the requested page while it is
+// being received. Returns the token for the next page, or "" if there are no more.
+func ListStream(
+	ctxt context.Context,
+	cmd *ListRequest,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+	onRecord func(r *Record) error,
+) (string, error) {
+	sp, err := ListRawStream(ctxt, cmd, adpt, logger)
+	if err != nil {
+		return "", err
+	}
+	defer sp.Close()
+
+	var hasMore bool
+	var nextPageToken string
+	err = adapter.StreamObject(sp.Decoder(), func(name string, dec *json.Decoder) error {
+		switch name {
+		case "records":
+			return adapter.StreamArray(dec, func(dec *json.Decoder) error {
+				var r Record
+				if err := dec.Decode(&r); err != nil {
+					return err
+				}
+				return onRecord(&r)
+			})
+		case "hasMore":
+			return dec.Decode(&hasMore)
+		case "nextPageToken":
+			return dec.Decode(&nextPageToken)
+		default:
+			return adapter.SkipValue(dec)
+		}
+	})
+	if err != nil {
+		logger.Warn("while streaming records", log.Error(err))
+		return "", err
+	}
+	if !hasMore {
+		nextPageToken = ""
+	}
+	return nextPageToken, nil
+}
+
+// RecordIterator walks through all records matching a ListRequest, fetching
+// one page at a time when needed.
+//
+//	it := record.ListAll(ctxt, req, adpt, logger)
+//	for it.Next() {
+//		r := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type RecordIterator struct {
+	ctxt    context.Context
+	req     ListRequest
+	adpt    *adapter.Adapter
+	logger  *log.Logger
+	page    []Record
+	idx     int
+	hasMore bool
+	err     error
+}
+
+// ListAll returns an iterator over all records matching 'cmd' across all pages.
+// 'cmd.Limit' sets the page size. Cancelling 'ctxt' stops the iteration.
+func ListAll(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) *RecordIterator {
+	return &RecordIterator{ctxt: ctxt, req: *cmd, adpt: adpt, logger: logger, idx: -1, hasMore: true}
+}
+
+// Next advances to the next record and returns false when there are no more
+// records, or an error occurred.
+func (it *RecordIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.err = it.ctxt.Err(); it.err != nil {
+		return false
+	}
+	it.idx++
+	for it.idx >= len(it.page) {
+		if !it.hasMore {
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Record returns the current record
+func (it *RecordIterator) Record() *Record {
+	if it.idx < 0 || it.idx >= len(it.page) {
+		return nil
+	}
+	return &it.page[it.idx]
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+func (it *RecordIterator) fetch() error {
+	pyl, err := ListRaw(it.ctxt, &it.req, it.adpt, it.logger)
+	if err != nil {
+		return err
+	}
+	res := ListResult{}
+	if err := json.Unmarshal(pyl.AsBytes(), &res); err != nil {
+		it.logger.Warn("while decoding records", log.Error(err))
+		return err
+	}
+	it.page = res.Records
+	it.idx = 0
+	// guard against a server handing out the same page again
+	it.hasMore = res.HasMore && res.NextPageToken != "" && res.NextPageToken != it.req.PageToken
+	// following pages are solely identified by their token
+	it.req.PageToken = res.NextPageToken
+	it.req.Offset = -1
+	return nil
+}
+
+func listPath(cmd *ListRequest, adpt *adapter.Adapter) string {
 	path := recordPath(nil, adpt)
 
 	pa := []string{}
 	if cmd.Aspects != "" {
 		pa = append(pa, "aspect="+url.QueryEscape(cmd.Aspects))
 	}
+	if cmd.OptionalAspects != "" {
+		pa = append(pa, "optionalAspect="+url.QueryEscape(cmd.OptionalAspects))
+	}
 	if len(cmd.AndQuery) > 0 {
 		for _, q := range cmd.AndQuery {
 			pa = append(pa, "aspectQuery="+q.asUrlQuery())
@@ -168,7 +314,7 @@ func ListRaw(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logg
 		path = path + "?" + strings.Join(pa, "&")
 	}
 	//fmt.Printf("PATH: %s\n", path)
-	return (*adpt).Get(ctxt, path, logger)
+	return path
 }
 
 func (t *QueryTerm) asUrlQuery() string {
@@ -177,6 +323,10 @@ func (t *QueryTerm) asUrlQuery() string {
 	}
 	v := fmt.Sprint(t.Value)
 	v = strings.ReplaceAll(v, ":", "%3A") // ':' is the separation character, so it needs to be escaped
+	if v != "" && strings.IndexByte("!~<>?", v[0]) >= 0 {
+		// would otherwise be read as (part of) the operator
+		v = fmt.Sprintf("%%%02X", v[0]) + v[1:]
+	}
 	op := t.Op
 	if op == "=" {
 		op = ""
@@ -216,15 +366,16 @@ func CreateRaw(ctxt context.Context, cmd *CreateRequest, adpt *adapter.Adapter,
 /**** READ ****/
 
 type ReadRequest struct {
-	Id         string
-	AddAspects string
-	Aspect     string
+	Id              string
+	AddAspects      string
+	OptionalAspects string // included if present, but not required
+	Aspect          string
 }
 
 func ReadRaw(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
 	path := recordPath(&cmd.Id, adpt)
-	if cmd.AddAspects != "" {
-		path = path + "?aspect=" + cmd.AddAspects
+	if cmd.AddAspects != "" || cmd.OptionalAspects != "" {
+		path = readPath(cmd, adpt)
 	} else if cmd.Aspect != "" {
 		path = path + "/aspects/" + cmd.Aspect
 	} else {
@@ -234,6 +385,54 @@ func ReadRaw(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logg
 	return (*adpt).Get(ctxt, path, logger)
 }
 
+// RecordSummary is returned when reading a record without any aspects
+type RecordSummary struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Aspects   []string `json:"aspects"`
+	SourceTag string   `json:"sourceTag,omitempty"`
+	TenantID  int      `json:"tenantId"`
+}
+
+// Read returns the record 'cmd.Id' with the aspects listed in 'cmd.AddAspects'
+// and 'cmd.OptionalAspects'
+func Read(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (Record, error) {
+	res := Record{}
+	err := adapter.GetAs(ctxt, *adpt, readPath(cmd, adpt), &res, logger)
+	return res, err
+}
+
+func readPath(cmd *ReadRequest, adpt *adapter.Adapter) string {
+	path := recordPath(&cmd.Id, adpt)
+	pa := []string{}
+	if cmd.AddAspects != "" {
+		pa = append(pa, "aspect="+cmd.AddAspects)
+	}
+	if cmd.OptionalAspects != "" {
+		pa = append(pa, "optionalAspect="+url.QueryEscape(cmd.OptionalAspects))
+	}
+	if len(pa) > 0 {
+		path = path + "?" + strings.Join(pa, "&")
+	}
+	return path
+}
+
+// ReadSummary returns the name and list of aspects of record 'cmd.Id'
+func ReadSummary(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (RecordSummary, error) {
+	path := recordPath(nil, adpt) + "/summary/" + cmd.Id
+	res := RecordSummary{}
+	err := adapter.GetAs(ctxt, *adpt, path, &res, logger)
+	return res, err
+}
+
+// ReadAspect returns aspect 'cmd.Aspect' of record 'cmd.Id'
+func ReadAspect(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (Aspect, error) {
+	path := recordPath(&cmd.Id, adpt) + "/aspects/" + cmd.Aspect
+	res := Aspect{}
+	err := adapter.GetAs(ctxt, *adpt, path, &res, logger)
+	return res, err
+}
+
 /**** UPDATE ****/
 
 type UpdateRequest = CreateRequest
@@ -263,6 +462,113 @@ func UpdateRaw(ctxt context.Context, cmd *UpdateRequest, adpt *adapter.Adapter,
 	return (*adpt).Put(ctxt, path, bytes.NewReader(body), logger)
 }
 
+type UpdateAspectRequest struct {
+	Id     string
+	Aspect string
+	Value  Aspect
+}
+
+// UpdateAspectRaw creates or replaces a single aspect of a record, leaving all
+// others untouched
+func UpdateAspectRaw(ctxt context.Context, cmd *UpdateAspectRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
+	path := recordPath(&cmd.Id, adpt) + "/aspects/" + cmd.Aspect
+	body, err := json.MarshalIndent(cmd.Value, "", "  ")
+	if err != nil {
+		logger.Error("error marshalling body.", log.Error(err))
+		return nil, err
+	}
+	return (*adpt).Put(ctxt, path, bytes.NewReader(body), logger)
+}
+
+// UpdateMode selects how Update changes an existing record
+type UpdateMode int
+
+const (
+	// ReplaceUpdate sends the whole record in one call through UpdateRaw,
+	// which only reads the record first if no name is given, as Magda
+	// requires one. Aspects not given are kept by Magda.
+	ReplaceUpdate UpdateMode = iota
+	// MergeUpdate only sends the aspects which differ from the current ones,
+	// each through its own call. Other aspects, as well as an empty name or
+	// source tag, are left untouched.
+	MergeUpdate
+)
+
+// UpdateResult lists the aspects of an update by what happened to them
+type UpdateResult struct {
+	Added     []string `json:"added"`     // only determined by MergeUpdate
+	Changed   []string `json:"changed"`   // all aspects for ReplaceUpdate
+	Unchanged []string `json:"unchanged"` // only determined by MergeUpdate
+}
+
+// Update changes record 'cmd.Id', which is created if it doesn't exist yet.
+// A record created by MergeUpdate without a name is named after its ID.
+func Update(ctxt context.Context, cmd *UpdateRequest, mode UpdateMode, adpt *adapter.Adapter, logger *log.Logger) (UpdateResult, error) {
+	res := UpdateResult{Added: []string{}, Changed: []string{}, Unchanged: []string{}}
+	names := make([]string, 0, len(cmd.Aspects))
+	for n := range cmd.Aspects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if mode == ReplaceUpdate {
+		res.Changed = names
+		_, err := UpdateRaw(ctxt, cmd, adpt, logger)
+		return res, err
+	}
+
+	current, err := Read(ctxt, &ReadRequest{Id: cmd.Id, OptionalAspects: strings.Join(names, ",")}, adpt, logger)
+	if errors.Is(err, adapter.ErrNotFound) {
+		res.Added = names
+		r := *cmd
+		if r.Name == "" {
+			r.Name = r.Id
+		}
+		_, err = CreateRaw(ctxt, &r, adpt, logger)
+		return res, err
+	} else if err != nil {
+		return res, err
+	}
+
+	for _, n := range names {
+		old, ok := current.Aspects[n]
+		var value interface{}
+		if err := jsonCopy(cmd.Aspects[n], &value); err != nil {
+			return res, err
+		}
+		switch {
+		case !ok:
+			res.Added = append(res.Added, n)
+		case !reflect.DeepEqual(old, value):
+			res.Changed = append(res.Changed, n)
+		default:
+			res.Unchanged = append(res.Unchanged, n)
+		}
+	}
+
+	patch := []PatchOp{}
+	if cmd.Name != "" && cmd.Name != current.Name {
+		patch = append(patch, PatchReplaceOp("/name", cmd.Name))
+	}
+	if cmd.SourceTag != "" && cmd.SourceTag != current.SourceTag {
+		// 'add' as the source tag may not be set yet
+		patch = append(patch, PatchAddOp("/sourceTag", cmd.SourceTag))
+	}
+	if len(patch) > 0 {
+		if _, err := PatchRecordRaw(ctxt, &PatchRecordRequest{Id: cmd.Id, Patch: patch}, adpt, logger); err != nil {
+			return res, err
+		}
+	}
+	for _, l := range [][]string{res.Added, res.Changed} {
+		for _, n := range l {
+			req := &UpdateAspectRequest{Id: cmd.Id, Aspect: n, Value: cmd.Aspects[n]}
+			if _, err := UpdateAspectRaw(ctxt, req, adpt, logger); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
 /**** DELETE ****/
 
 type DeleteRequest struct {
@@ -289,6 +595,61 @@ type HistoryRequest struct {
 }
 
 func HistoryRaw(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
+	return (*adpt).Get(ctxt, historyPath(cmd, adpt), logger)
+}
+
+// Event describes a single change to a record
+type Event struct {
+	ID        int64                  `json:"id"`
+	EventTime time.Time              `json:"eventTime"`
+	EventType EventType              `json:"eventType"`
+	UserID    string                 `json:"userId,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+	TenantID  int                    `json:"tenantId"`
+}
+
+type EventType string
+
+const (
+	CreateRecordEvent       EventType = "CreateRecord"
+	CreateRecordAspectEvent EventType = "CreateRecordAspect"
+	PatchRecordEvent        EventType = "PatchRecord"
+	PatchRecordAspectEvent  EventType = "PatchRecordAspect"
+	DeleteRecordEvent       EventType = "DeleteRecord"
+	DeleteRecordAspectEvent EventType = "DeleteRecordAspect"
+)
+
+type HistoryResult struct {
+	HasMore       bool    `json:"hasMore"`
+	NextPageToken string  `json:"nextPageToken"`
+	Events        []Event `json:"events"`
+}
+
+// History returns a page of events of record 'cmd.Id'. Use ReadVersion for the
+// record as of a specific event.
+func History(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter, logger *log.Logger) (HistoryResult, error) {
+	if cmd.EventId != "" {
+		return HistoryResult{}, fmt.Errorf("History doesn't support 'EventId', use ReadVersion instead")
+	}
+	res := HistoryResult{}
+	err := adapter.GetAs(ctxt, *adpt, historyPath(cmd, adpt), &res, logger)
+	return res, err
+}
+
+// ReadVersion returns record 'cmd.Id' as it was after event 'cmd.EventId'
+func ReadVersion(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter, logger *log.Logger) (Record, error) {
+	path := recordPath(&cmd.Id, adpt) + "/history/" + cmd.EventId
+	res := Record{}
+	err := adapter.GetAs(ctxt, *adpt, path, &res, logger)
+	return res, err
+}
+
+// HistoryRawStream is the streaming version of HistoryRaw. The returned payload needs to be closed.
+func HistoryRawStream(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.StreamPayload, error) {
+	return adapter.GetStream(ctxt, *adpt, historyPath(cmd, adpt), logger)
+}
+
+func historyPath(cmd *HistoryRequest, adpt *adapter.Adapter) string {
 	path := recordPath(&cmd.Id, adpt) + "/history"
 	if cmd.EventId != "" {
 		path = path + "/" + cmd.EventId
@@ -308,16 +669,13 @@ func HistoryRaw(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter
 		path = path + "?" + strings.Join(q, "&")
 	}
 	// fmt.Printf("PATH: %s\n", path)
-	return (*adpt).Get(ctxt, path, logger)
+	return path
 }
 
 /**** UTILS ****/
 
 func recordPath(id *string, adpt *adapter.Adapter) string {
-	path := "/api/v0/registry/records"
-	if (*adpt).SkipGateway() {
-		path = "/v0/records"
-	}
+	path := adapter.BasePath(*adpt, adapter.RegistryAPI) + "/records"
 	if id != nil {
 		path = path + "/" + *id
 	}
@@ -1,10 +1,17 @@
 package record
 
 import (
+	"context"
 	"encoding/json"
 	_ "fmt"
+	"net/http"
+	"net/http/httptest"
 	_ "regexp"
+	"strings"
 	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	log "go.uber.org/zap"
 )
 
 func TestListQuery(t *testing.T) {
@@ -52,3 +59,58 @@ func TestListQueryJson(t *testing.T) {
 		t.Errorf("expected '%s', but got '%s'", exp, p)
 	}
 }
+
+func TestListStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"records": [{"id": "a", "name": "A"}, {"id": "b", "name": "B"}], "hasMore": true, "nextPageToken": "7"}`))
+	}))
+	defer srv.Close()
+	adpt := adapter.RestAdapter(adapter.ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://")})
+
+	names := []string{}
+	token, err := ListStream(context.Background(), &ListRequest{Offset: -1, Limit: -1}, &adpt, log.NewNop(), func(r *Record) error {
+		names = append(names, r.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if strings.Join(names, ",") != "A,B" || token != "7" {
+		t.Errorf("unexpected result %v - '%s'", names, token)
+	}
+}
+
+func TestListAll(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"records": [{"id": "a"}, {"id": "b"}], "hasMore": true, "nextPageToken": "p2"}`,
+		"p2": `{"records": [], "hasMore": true, "nextPageToken": "p3"}`,
+		"p3": `{"records": [{"id": "c"}], "hasMore": false}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pages[r.URL.Query().Get("pageToken")]))
+	}))
+	defer srv.Close()
+	adpt := adapter.RestAdapter(adapter.ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://")})
+
+	ids := []string{}
+	it := ListAll(context.Background(), &ListRequest{Offset: -1, Limit: 2}, &adpt, log.NewNop())
+	for it.Next() {
+		ids = append(ids, it.Record().ID)
+	}
+	if it.Err() != nil {
+		t.Fatalf("unexpected error - %v", it.Err())
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Errorf("unexpected records %v", ids)
+	}
+
+	ctxt, cancel := context.WithCancel(context.Background())
+	it = ListAll(ctxt, &ListRequest{Offset: -1, Limit: 2}, &adpt, log.NewNop())
+	if !it.Next() {
+		t.Fatalf("expected first record")
+	}
+	cancel()
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("expected iteration to stop when cancelled, but got %v", it.Err())
+	}
+}
@@ -0,0 +1,208 @@
+package record_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	"github.com/maxott/magda-cli/pkg/record"
+	log "go.uber.org/zap"
+)
+
+func createOrder(t *testing.T, adpt *adapter.Adapter, id string, status string, value float64) {
+	r := record.CreateRequest{Id: id, Name: "Order " + id, Aspects: record.Aspects{
+		"cse-order": record.Aspect{"status": status, "parameters": []interface{}{map[string]interface{}{"value": value}}},
+	}}
+	if _, err := record.CreateRaw(context.Background(), &r, adpt, log.NewNop()); err != nil {
+		t.Fatalf("creating record '%s' - %v", id, err)
+	}
+}
+
+func TestTypedResults(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	createOrder(t, adpt, "o1", "pending", 5)
+	patch := &record.PatchAspectRequest{Id: "o1", Aspect: "cse-order", Patch: []record.PatchOp{
+		record.PatchReplaceOp("/status", "done"),
+	}}
+	if _, err := record.PatchAspectRaw(ctxt, patch, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	r, err := record.Read(ctxt, &record.ReadRequest{Id: "o1", AddAspects: "cse-order"}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if r.Name != "Order o1" || r.Aspects["cse-order"].(map[string]interface{})["status"] != "done" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	sum, err := record.ReadSummary(ctxt, &record.ReadRequest{Id: "o1"}, adpt, logger)
+	if err != nil || len(sum.Aspects) != 1 || sum.Aspects[0] != "cse-order" {
+		t.Fatalf("unexpected summary %+v - %v", sum, err)
+	}
+	a, err := record.ReadAspect(ctxt, &record.ReadRequest{Id: "o1", Aspect: "cse-order"}, adpt, logger)
+	if err != nil || a["status"] != "done" {
+		t.Fatalf("unexpected aspect %+v - %v", a, err)
+	}
+
+	h, err := record.History(ctxt, &record.HistoryRequest{Id: "o1", Offset: -1, Limit: -1}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(h.Events) != 3 || h.Events[2].EventType != record.PatchRecordAspectEvent || h.Events[0].EventTime.IsZero() {
+		t.Fatalf("unexpected history %+v", h)
+	}
+	v, err := record.ReadVersion(ctxt, &record.HistoryRequest{Id: "o1", EventId: strconv.FormatInt(h.Events[1].ID, 10)}, adpt, logger)
+	if err != nil || v.Aspects["cse-order"].(map[string]interface{})["status"] != "pending" {
+		t.Fatalf("unexpected version %+v - %v", v, err)
+	}
+}
+
+func TestReadAtRestore(t *testing.T) {
+	reg := fakeregistry.New()
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	reg.Now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	adpt := reg.TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	createOrder(t, adpt, "o1", "pending", 5)
+	patch := &record.PatchAspectRequest{Id: "o1", Aspect: "cse-order", Patch: []record.PatchOp{
+		record.PatchReplaceOp("/status", "done"),
+		record.PatchAddOp("/parameters/-", map[string]interface{}{"value": 7}),
+	}}
+	if _, err := record.PatchAspectRaw(ctxt, patch, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	update := &record.UpdateRequest{Id: "o1", Name: "Renamed", SourceTag: "v2", Aspects: record.Aspects{"note": {"text": "hi"}}}
+	if _, err := record.UpdateRaw(ctxt, update, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := record.DeleteRaw(ctxt, &record.DeleteRequest{Id: "o1", AspectName: "cse-order"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	events, err := record.AllEvents(ctxt, &record.HistoryRequest{Id: "o1", Offset: -1, Limit: 2}, adpt, logger)
+	if err != nil || len(events) != 6 {
+		t.Fatalf("unexpected events %+v - %v", events, err)
+	}
+	// every replayed version matches the registry's snapshot
+	for i, e := range events {
+		r, last, err := record.ReadAt(ctxt, &record.ReadAtRequest{Id: "o1", EventId: e.ID}, adpt, logger)
+		if err != nil || last.ID != e.ID {
+			t.Fatalf("event %d: unexpected error - %v", e.ID, err)
+		}
+		v, err := record.ReadVersion(ctxt, &record.HistoryRequest{Id: "o1", EventId: strconv.FormatInt(e.ID, 10)}, adpt, logger)
+		if err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+		if changes := record.DiffRecord(&v, r); len(changes) != 0 {
+			t.Errorf("event %d: replay differs from snapshot %+v", e.ID, changes)
+		}
+		r2, _, err := record.ReadAt(ctxt, &record.ReadAtRequest{Id: "o1", Time: start.Add(time.Duration(i+1) * time.Minute)}, adpt, logger)
+		if err != nil || len(record.DiffRecord(r, r2)) != 0 {
+			t.Errorf("event %d: unexpected version at time %+v - %v", e.ID, r2, err)
+		}
+	}
+	if _, _, err := record.ReadAt(ctxt, &record.ReadAtRequest{Id: "o1", Time: start}, adpt, logger); err != record.ErrNoVersion {
+		t.Errorf("expected ErrNoVersion, got %v", err)
+	}
+
+	// back to the state after the aspect patch
+	target, _, err := record.ReadAt(ctxt, &record.ReadAtRequest{Id: "o1", EventId: events[2].ID}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	current, err := record.Read(ctxt, &record.ReadRequest{Id: "o1", OptionalAspects: "cse-order,note"}, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if err := record.Restore(ctxt, &current, target, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	restored, _ := record.Read(ctxt, &record.ReadRequest{Id: "o1", OptionalAspects: "cse-order,note"}, adpt, logger)
+	// the source tag 'v2' is cleared as well
+	if changes := record.DiffRecord(&restored, target); len(changes) != 0 || restored.Name != "Order o1" || restored.SourceTag != "" {
+		t.Errorf("unexpected restored record %+v, differences %+v", restored, changes)
+	}
+
+	// the source tag of a new record is part of its first version
+	create := &record.CreateRequest{Id: "o2", Name: "Order o2", SourceTag: "v1", Aspects: record.Aspects{}}
+	if _, err := record.CreateRaw(ctxt, create, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	events, err = record.AllEvents(ctxt, &record.HistoryRequest{Id: "o2", Offset: -1, Limit: -1}, adpt, logger)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("unexpected events %+v - %v", events, err)
+	}
+	if r, _, err := record.ReadAt(ctxt, &record.ReadAtRequest{Id: "o2", EventId: events[0].ID}, adpt, logger); err != nil || r.SourceTag != "v1" {
+		t.Errorf("unexpected first version %+v - %v", r, err)
+	}
+}
+
+func TestUpdateModes(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	createOrder(t, adpt, "o1", "pending", 5)
+	note := record.Aspect{"text": "hi"}
+	order := record.Aspect{"status": "pending", "parameters": []interface{}{map[string]interface{}{"value": 5}}}
+
+	// merging only touches what differs
+	res, err := record.Update(ctxt, &record.UpdateRequest{Id: "o1", SourceTag: "t1", Aspects: record.Aspects{
+		"cse-order": order, "note": note,
+	}}, record.MergeUpdate, adpt, logger)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if len(res.Added) != 1 || res.Added[0] != "note" || len(res.Changed) != 0 || len(res.Unchanged) != 1 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	res, err = record.Update(ctxt, &record.UpdateRequest{Id: "o1", Aspects: record.Aspects{
+		"note": {"text": "bye"},
+	}}, record.MergeUpdate, adpt, logger)
+	if err != nil || len(res.Changed) != 1 || len(res.Added) != 0 {
+		t.Fatalf("unexpected result %+v - %v", res, err)
+	}
+	r, _ := record.Read(ctxt, &record.ReadRequest{Id: "o1", OptionalAspects: "cse-order,note"}, adpt, logger)
+	if r.Name != "Order o1" || r.SourceTag != "t1" || len(r.Aspects) != 2 || r.Aspects["note"].(map[string]interface{})["text"] != "bye" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	// only the changed aspect caused an event
+	h, _ := record.History(ctxt, &record.HistoryRequest{Id: "o1", Offset: -1, Limit: -1}, adpt, logger)
+	if n := len(h.Events); n != 5 || h.Events[n-1].EventType != record.PatchRecordAspectEvent {
+		t.Fatalf("unexpected events %+v", h.Events)
+	}
+
+	// replacing reports all given aspects as changed, and keeps the others
+	res, err = record.Update(ctxt, &record.UpdateRequest{Id: "o1", Name: "Order", Aspects: record.Aspects{
+		"note": note, "other": {},
+	}}, record.ReplaceUpdate, adpt, logger)
+	if err != nil || len(res.Changed) != 2 || len(res.Added) != 0 {
+		t.Fatalf("unexpected result %+v - %v", res, err)
+	}
+	r, _ = record.Read(ctxt, &record.ReadRequest{Id: "o1", OptionalAspects: "cse-order,note,other"}, adpt, logger)
+	if r.Name != "Order" || len(r.Aspects) != 3 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	// missing records are created, named after their ID if no name is given
+	res, err = record.Update(ctxt, &record.UpdateRequest{Id: "o2", Aspects: record.Aspects{"note": note}}, record.MergeUpdate, adpt, logger)
+	if err != nil || len(res.Added) != 1 {
+		t.Fatalf("unexpected result %+v - %v", res, err)
+	}
+	r, err = record.Read(ctxt, &record.ReadRequest{Id: "o2", OptionalAspects: "note"}, adpt, logger)
+	if err != nil || r.Name != "o2" || len(r.Aspects) != 1 {
+		t.Fatalf("unexpected record %+v - %v", r, err)
+	}
+}
@@ -0,0 +1,227 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	log "go.uber.org/zap"
+)
+
+/**** REPLAY ****/
+
+// ReadAtRequest selects a point in the history of record 'Id', either by
+// event ID or by time
+type ReadAtRequest struct {
+	Id      string
+	EventId int64     // include all events up to and including this one
+	Time    time.Time // include all events up to and including this time, if 'EventId' is 0
+}
+
+// ErrNoVersion is returned by ReadAt if the record didn't exist at the
+// requested point in time
+var ErrNoVersion = errors.New("record didn't exist at that point")
+
+// ReadAt rebuilds record 'cmd.Id' with all its aspects as it was at the point
+// selected by 'cmd', by replaying its events. It also returns the last event
+// replayed.
+func ReadAt(ctxt context.Context, cmd *ReadAtRequest, adpt *adapter.Adapter, logger *log.Logger) (*Record, *Event, error) {
+	if cmd.EventId == 0 && cmd.Time.IsZero() {
+		return nil, nil, fmt.Errorf("ReadAt requires either 'EventId' or 'Time'")
+	}
+	events, err := AllEvents(ctxt, &HistoryRequest{Id: cmd.Id, Offset: -1, Limit: -1}, adpt, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := sort.Search(len(events), func(i int) bool {
+		if cmd.EventId != 0 {
+			return events[i].ID > cmd.EventId
+		}
+		return events[i].EventTime.After(cmd.Time)
+	})
+	if n == 0 {
+		return nil, nil, ErrNoVersion
+	}
+	r, err := Replay(events[:n])
+	if err != nil {
+		return nil, nil, err
+	}
+	if r == nil {
+		return nil, nil, ErrNoVersion
+	}
+	return r, &events[n-1], nil
+}
+
+// AllEvents returns all events of record 'cmd.Id', ordered by their ID,
+// following the page tokens
+func AllEvents(ctxt context.Context, cmd *HistoryRequest, adpt *adapter.Adapter, logger *log.Logger) ([]Event, error) {
+	req := *cmd
+	events := []Event{}
+	for {
+		res, err := History(ctxt, &req, adpt, logger)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, res.Events...)
+		if !res.HasMore || res.NextPageToken == "" || res.NextPageToken == req.PageToken {
+			break
+		}
+		req.PageToken = res.NextPageToken
+		req.Offset = -1
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// Replay rebuilds a record from its 'events', which need to start with its
+// creation. It returns nil if the record was deleted by the last of them.
+func Replay(events []Event) (*Record, error) {
+	var r *Record
+	for _, e := range events {
+		str := func(key string) string {
+			s, _ := e.Data[key].(string)
+			return s
+		}
+		if r == nil && e.EventType != CreateRecordEvent {
+			return nil, fmt.Errorf("event %d (%s) - record doesn't exist", e.ID, e.EventType)
+		}
+		switch e.EventType {
+		case CreateRecordEvent:
+			r = &Record{ID: str("recordId"), Name: str("name"), SourceTag: str("sourceTag"), TenantID: e.TenantID,
+				Aspects: map[string]interface{}{}}
+		case PatchRecordEvent:
+			doc := map[string]interface{}{"id": r.ID, "name": r.Name, "sourceTag": r.SourceTag, "aspects": r.Aspects}
+			patched, err := ApplyPatch(doc, eventPatch(e))
+			if err != nil {
+				return nil, fmt.Errorf("event %d (%s) - %s", e.ID, e.EventType, err)
+			}
+			nr := Record{TenantID: r.TenantID}
+			if err := jsonCopy(patched, &nr); err != nil {
+				return nil, fmt.Errorf("event %d (%s) - %s", e.ID, e.EventType, err)
+			}
+			if nr.Aspects == nil {
+				nr.Aspects = map[string]interface{}{}
+			}
+			r = &nr
+		case CreateRecordAspectEvent:
+			r.Aspects[str("aspectId")] = e.Data["aspect"]
+		case PatchRecordAspectEvent:
+			aspect := str("aspectId")
+			doc, ok := r.Aspects[aspect]
+			if !ok {
+				doc = map[string]interface{}{}
+			}
+			patched, err := ApplyPatch(doc, eventPatch(e))
+			if err != nil {
+				return nil, fmt.Errorf("event %d (%s of '%s') - %s", e.ID, e.EventType, aspect, err)
+			}
+			r.Aspects[aspect] = patched
+		case DeleteRecordAspectEvent:
+			delete(r.Aspects, str("aspectId"))
+		case DeleteRecordEvent:
+			r = nil
+		}
+	}
+	return r, nil
+}
+
+func eventPatch(e Event) []PatchOp {
+	ops := []PatchOp{}
+	if l, ok := e.Data["patch"].([]interface{}); ok {
+		for _, op := range l {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+/**** RESTORE ****/
+
+// DiffRecord returns the changes turning record 'from' into 'to', with paths
+// '/name', '/sourceTag' and '/aspects/<aspect>/...'
+func DiffRecord(from *Record, to *Record) []Change {
+	doc := func(r *Record) map[string]interface{} {
+		aspects := map[string]interface{}{}
+		for k, v := range r.Aspects {
+			aspects[k] = v
+		}
+		return map[string]interface{}{"name": r.Name, "sourceTag": r.SourceTag, "aspects": aspects}
+	}
+	return Diff(doc(from), doc(to))
+}
+
+// Restore changes record 'current' in Magda to match 'target'. Aspects only
+// in 'current' are deleted, new ones are added, and changed ones patched.
+func Restore(ctxt context.Context, current *Record, target *Record, adpt *adapter.Adapter, logger *log.Logger) error {
+	update := &UpdateRequest{Id: target.ID, Name: target.Name, SourceTag: target.SourceTag, Aspects: Aspects{}}
+	needsUpdate := current.Name != target.Name || current.SourceTag != target.SourceTag
+	deletes := []string{}
+	patches := map[string][]PatchOp{}
+	for _, c := range DiffRecord(current, target) {
+		if !strings.HasPrefix(c.Path, "/aspects/") {
+			continue // name and source tag
+		}
+		rest := strings.TrimPrefix(c.Path, "/aspects/")
+		aspect, path := rest, ""
+		if i := strings.Index(rest, "/"); i >= 0 {
+			aspect, path = rest[:i], rest[i:]
+		}
+		aspect = strings.ReplaceAll(strings.ReplaceAll(aspect, "~1", "/"), "~0", "~")
+		switch {
+		case path == "" && c.Op == "remove":
+			deletes = append(deletes, aspect)
+		case path == "":
+			obj, ok := c.NewValue.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("aspect '%s' is not an object", aspect)
+			}
+			update.Aspects[aspect] = obj
+			needsUpdate = true
+		default:
+			c.Path = path
+			patches[aspect] = append(patches[aspect], c.PatchOp())
+		}
+	}
+
+	if needsUpdate {
+		if err := restoreRecord(ctxt, update, adpt, logger); err != nil {
+			return err
+		}
+	}
+	aspects := make([]string, 0, len(patches))
+	for a := range patches {
+		aspects = append(aspects, a)
+	}
+	sort.Strings(aspects)
+	for _, a := range aspects {
+		if _, err := PatchAspectRaw(ctxt, &PatchAspectRequest{Id: target.ID, Aspect: a, Patch: patches[a]}, adpt, logger); err != nil {
+			return err
+		}
+	}
+	for _, a := range deletes {
+		if _, err := DeleteRaw(ctxt, &DeleteRequest{Id: target.ID, AspectName: a}, adpt, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreRecord replaces name, source tag and the aspects in 'cmd'. Unlike
+// UpdateRaw, an empty source tag is sent as well, clearing the current one.
+func restoreRecord(ctxt context.Context, cmd *UpdateRequest, adpt *adapter.Adapter, logger *log.Logger) error {
+	body, err := json.MarshalIndent(struct {
+		*UpdateRequest
+		SourceTag string `json:"sourceTag"`
+	}{cmd, cmd.SourceTag}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = (*adpt).Put(ctxt, recordPath(&cmd.Id, adpt), bytes.NewReader(body), logger)
+	return err
+}
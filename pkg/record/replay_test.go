@@ -0,0 +1,59 @@
+package record
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	var events []Event
+	err := json.Unmarshal([]byte(`[
+		{"id": 1, "eventType": "CreateRecord", "data": {"recordId": "r1", "name": "R"}},
+		{"id": 2, "eventType": "CreateRecordAspect", "data": {"recordId": "r1", "aspectId": "a", "aspect": {"x": 1, "l": []}}},
+		{"id": 3, "eventType": "PatchRecordAspect", "data": {"recordId": "r1", "aspectId": "a", "patch": [
+			{"op": "replace", "path": "/x", "value": 2}, {"op": "add", "path": "/l/-", "value": "y"}]}},
+		{"id": 4, "eventType": "PatchRecordAspect", "data": {"recordId": "r1", "aspectId": "b", "patch": [
+			{"op": "add", "path": "/z", "value": true}]}},
+		{"id": 5, "eventType": "PatchRecord", "data": {"recordId": "r1", "patch": [{"op": "replace", "path": "/name", "value": "S"}]}},
+		{"id": 6, "eventType": "DeleteRecordAspect", "data": {"recordId": "r1", "aspectId": "a"}},
+		{"id": 7, "eventType": "DeleteRecord", "data": {"recordId": "r1"}}
+	]`), &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Replay(events[:5])
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	exp := &Record{ID: "r1", Name: "S", Aspects: map[string]interface{}{
+		"a": map[string]interface{}{"x": 2.0, "l": []interface{}{"y"}},
+		"b": map[string]interface{}{"z": true},
+	}}
+	if changes := DiffRecord(exp, r); len(changes) != 0 || r.ID != "r1" {
+		t.Errorf("unexpected record %+v, differences %+v", r, changes)
+	}
+	if r, err = Replay(events[:6]); err != nil || len(r.Aspects) != 1 {
+		t.Errorf("unexpected record %+v - %v", r, err)
+	}
+	if r, err = Replay(events); err != nil || r != nil {
+		t.Errorf("expected deleted record, got %+v - %v", r, err)
+	}
+	if _, err = Replay(events[1:]); err == nil {
+		t.Errorf("expected error for missing creation")
+	}
+}
+
+func TestDiffRecord(t *testing.T) {
+	from := &Record{Name: "A", Aspects: map[string]interface{}{"x/y": map[string]interface{}{"v": 1.0}, "gone": map[string]interface{}{}}}
+	to := &Record{Name: "B", SourceTag: "t", Aspects: map[string]interface{}{"x/y": map[string]interface{}{"v": 2.0}, "new": map[string]interface{}{}}}
+	paths := []string{}
+	for _, c := range DiffRecord(from, to) {
+		paths = append(paths, c.Op+" "+c.Path)
+	}
+	exp := "remove /aspects/gone,replace /aspects/x~1y/v,add /aspects/new,replace /name,replace /sourceTag"
+	if s := strings.Join(paths, ","); s != exp {
+		t.Errorf("unexpected changes %s", s)
+	}
+}
@@ -0,0 +1,375 @@
+package record
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**** WHERE ****/
+
+// ParseWhere compiles a query expression into the terms for 'AndQuery' and
+// 'OrQuery' of a ListRequest, like
+//
+//	cse-order.status = "pending" and (cse-order.amount >= 10 or cse-order.urgent = true)
+//
+// A term compares an aspect path with a quoted string, a number, or a
+// boolean, using one of '=', '!=', '>', '>=', '<', '<=', 'like', 'not like'
+// (case insensitive patterns with '%' as wildcard), '~' or '!~' (case
+// insensitive regular expressions). 'and' binds stronger than 'or', and
+// terms can be grouped by parentheses.
+//
+// As Magda requires a record to match all 'and' terms and at least one of
+// the 'or' terms, only expressions of that form are supported.
+func ParseWhere(expr string) (and []QueryTerm, or []QueryTerm, err error) {
+	p := &whereParser{lexer: whereLexer{expr: expr}}
+	p.next()
+	n, err := p.parseOr()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("expected 'and' or 'or', but got %s", p.tok)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return n.compile(expr)
+}
+
+// WhereError describes a problem with a query expression. 'Pos' is the
+// byte offset in the expression where it was found.
+type WhereError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *WhereError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+/**** AST ****/
+
+type whereNode struct {
+	op       string // "term", "and", "or"
+	term     QueryTerm
+	children []*whereNode
+	pos      int
+}
+
+func (n *whereNode) compile(expr string) ([]QueryTerm, []QueryTerm, error) {
+	and, or := []QueryTerm{}, []QueryTerm{}
+	switch n.op {
+	case "term":
+		and = append(and, n.term)
+	case "or":
+		terms, err := n.terms(expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		or = terms
+	case "and":
+		for _, c := range n.children {
+			switch c.op {
+			case "term":
+				and = append(and, c.term)
+			case "or":
+				if len(or) > 0 {
+					return nil, nil, unsupported(expr, c.pos, "only one group of 'or' terms is supported")
+				}
+				terms, err := c.terms(expr)
+				if err != nil {
+					return nil, nil, err
+				}
+				or = terms
+			}
+		}
+	}
+	return and, or, nil
+}
+
+// terms returns the terms of an 'or' node, which may only contain terms
+func (n *whereNode) terms(expr string) ([]QueryTerm, error) {
+	terms := []QueryTerm{}
+	for _, c := range n.children {
+		if c.op != "term" {
+			return nil, unsupported(expr, c.pos, "'or' can only combine single terms")
+		}
+		terms = append(terms, c.term)
+	}
+	return terms, nil
+}
+
+func unsupported(expr string, pos int, msg string) error {
+	return &WhereError{Expr: expr, Pos: pos,
+		Msg: msg + ", Magda only supports terms which all need to match and one group of which any needs to match, like 'a = 1 and (b = 2 or c = 3)'"}
+}
+
+// combine flattens nested nodes of the same kind
+func combine(op string, left *whereNode, right *whereNode) *whereNode {
+	n := &whereNode{op: op, pos: left.pos}
+	for _, c := range []*whereNode{left, right} {
+		if c.op == op {
+			n.children = append(n.children, c.children...)
+		} else {
+			n.children = append(n.children, c)
+		}
+	}
+	return n
+}
+
+/**** PARSER ****/
+
+type whereParser struct {
+	lexer whereLexer
+	tok   token
+}
+
+func (p *whereParser) next() {
+	p.tok = p.lexer.next()
+}
+
+func (p *whereParser) errorf(format string, args ...interface{}) error {
+	if p.tok.kind == tokError {
+		return &WhereError{Expr: p.lexer.expr, Pos: p.tok.pos, Msg: p.tok.text}
+	}
+	return &WhereError{Expr: p.lexer.expr, Pos: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *whereParser) parseOr() (*whereNode, error) {
+	n, err := p.parseAnd()
+	for err == nil && p.tok.isKeyword("or") {
+		p.next()
+		var right *whereNode
+		if right, err = p.parseAnd(); err == nil {
+			n = combine("or", n, right)
+		}
+	}
+	return n, err
+}
+
+func (p *whereParser) parseAnd() (*whereNode, error) {
+	n, err := p.parsePrimary()
+	for err == nil && p.tok.isKeyword("and") {
+		p.next()
+		var right *whereNode
+		if right, err = p.parsePrimary(); err == nil {
+			n = combine("and", n, right)
+		}
+	}
+	return n, err
+}
+
+func (p *whereParser) parsePrimary() (*whereNode, error) {
+	if p.tok.kind == tokLParen {
+		pos := p.tok.pos
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ')' to close '(' at position %d, but got %s", pos+1, p.tok)
+		}
+		p.next()
+		if n.op != "term" {
+			n.pos = pos
+		}
+		return n, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *whereParser) parseTerm() (*whereNode, error) {
+	if p.tok.kind != tokWord || isReserved(p.tok.text) {
+		return nil, p.errorf("expected an aspect path, but got %s", p.tok)
+	}
+	n := &whereNode{op: "term", pos: p.tok.pos}
+	n.term.Path = p.tok.text
+	p.next()
+
+	var err error
+	if n.term.Op, err = p.parseOp(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.tok.kind == tokString:
+		n.term.Value = p.tok.text
+	case p.tok.kind == tokNumber:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number '%s'", p.tok.text)
+		}
+		n.term.Value = f
+	case p.tok.isKeyword("true"):
+		n.term.Value = true
+	case p.tok.isKeyword("false"):
+		n.term.Value = false
+	case p.tok.kind == tokWord:
+		return nil, p.errorf("expected a value, but got %s (strings need to be quoted)", p.tok)
+	default:
+		return nil, p.errorf("expected a value, but got %s", p.tok)
+	}
+	p.next()
+	return n, nil
+}
+
+func (p *whereParser) parseOp() (QueryOp, error) {
+	if p.tok.kind == tokOp {
+		var op QueryOp
+		switch p.tok.text {
+		case "=", "==":
+			op = Equal
+		case "!=", "<>":
+			op = NotEqual
+		case ">":
+			op = GreaterThan
+		case ">=":
+			op = GreaterEqualThan
+		case "<":
+			op = LessThan
+		case "<=":
+			op = LessEqualThen
+		case "~":
+			op = MatchRegExp
+		case "!~":
+			op = NotMatchRegExp
+		default:
+			return UnknownOp, p.errorf("unknown operator '%s'", p.tok.text)
+		}
+		p.next()
+		return op, nil
+	}
+	if p.tok.isKeyword("like") {
+		p.next()
+		return MatchPattern, nil
+	}
+	if p.tok.isKeyword("not") {
+		p.next()
+		if !p.tok.isKeyword("like") {
+			return UnknownOp, p.errorf("expected 'like' after 'not', but got %s", p.tok)
+		}
+		p.next()
+		return NotMatchPattern, nil
+	}
+	return UnknownOp, p.errorf("expected an operator like '=', '>=' or 'like', but got %s", p.tok)
+}
+
+/**** LEXER ****/
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokError
+	tokWord // path or keyword
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+func (t token) isKeyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+func isReserved(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not", "like", "true", "false":
+		return true
+	}
+	return false
+}
+
+type whereLexer struct {
+	expr string
+	pos  int
+}
+
+func (l *whereLexer) next() token {
+	for l.pos < len(l.expr) && strings.IndexByte(" \t\r\n", l.expr[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.expr) {
+		return token{kind: tokEOF, pos: start}
+	}
+	c := l.expr[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}
+	case c == '"' || c == '\'':
+		return l.quoted(c)
+	case isDigit(c) || (c == '-' || c == '+') && l.pos+1 < len(l.expr) && (isDigit(l.expr[l.pos+1]) || l.expr[l.pos+1] == '.'):
+		l.pos++
+		for l.pos < len(l.expr) && (isDigit(l.expr[l.pos]) || strings.IndexByte(".eE", l.expr[l.pos]) >= 0 ||
+			(l.expr[l.pos] == '-' || l.expr[l.pos] == '+') && (l.expr[l.pos-1] == 'e' || l.expr[l.pos-1] == 'E')) {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.expr[start:l.pos], pos: start}
+	case strings.IndexByte("=!<>~", c) >= 0:
+		l.pos++
+		for l.pos < len(l.expr) && strings.IndexByte("=!<>~", l.expr[l.pos]) >= 0 {
+			l.pos++
+		}
+		return token{kind: tokOp, text: l.expr[start:l.pos], pos: start}
+	case isWordChar(c):
+		for l.pos < len(l.expr) && (isWordChar(l.expr[l.pos]) || isDigit(l.expr[l.pos]) || l.expr[l.pos] == '.' || l.expr[l.pos] == '-') {
+			l.pos++
+		}
+		return token{kind: tokWord, text: l.expr[start:l.pos], pos: start}
+	default:
+		l.pos = len(l.expr)
+		return token{kind: tokError, text: fmt.Sprintf("unexpected character '%c'", c), pos: start}
+	}
+}
+
+// quoted reads a string in double or single quotes, with '\' escaping the
+// quote and itself
+func (l *whereLexer) quoted(q byte) token {
+	start := l.pos
+	var sb strings.Builder
+	for l.pos++; l.pos < len(l.expr); l.pos++ {
+		c := l.expr[l.pos]
+		if c == '\\' && l.pos+1 < len(l.expr) {
+			l.pos++
+			sb.WriteByte(l.expr[l.pos])
+			continue
+		}
+		if c == q {
+			l.pos++
+			return token{kind: tokString, text: sb.String(), pos: start}
+		}
+		sb.WriteByte(c)
+	}
+	return token{kind: tokError, text: "unterminated string", pos: start}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' || c >= 0x80
+}
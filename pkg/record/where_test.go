@@ -0,0 +1,104 @@
+package record
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWhere(t *testing.T) {
+	term := func(path string, op QueryOp, value interface{}) QueryTerm {
+		return QueryTerm{Path: path, Op: op, Value: value}
+	}
+	cases := []struct {
+		expr string
+		and  []QueryTerm
+		or   []QueryTerm
+	}{
+		{`cse-order.status = "pending"`, []QueryTerm{term("cse-order.status", Equal, "pending")}, []QueryTerm{}},
+		{`cse-order.status = "pending" and cse-order.parameters.0.value >= 10`, []QueryTerm{
+			term("cse-order.status", Equal, "pending"),
+			term("cse-order.parameters.0.value", GreaterEqualThan, 10.0),
+		}, []QueryTerm{}},
+		{`a.b != 'x\'y' or a.c < -1.5 or a.d like "%foo%"`, []QueryTerm{}, []QueryTerm{
+			term("a.b", NotEqual, "x'y"),
+			term("a.c", LessThan, -1.5),
+			term("a.d", MatchPattern, "%foo%"),
+		}},
+		{`a.b=true AND (a.c ~ "^x" OR a.d NOT LIKE "y%") and ((a.e <= 1e3))`, []QueryTerm{
+			term("a.b", Equal, true),
+			term("a.e", LessEqualThen, 1000.0),
+		}, []QueryTerm{
+			term("a.c", MatchRegExp, "^x"),
+			term("a.d", NotMatchPattern, "y%"),
+		}},
+		{`(a.b > 1 or a.c !~ "z") and a.d = false`, []QueryTerm{term("a.d", Equal, false)}, []QueryTerm{
+			term("a.b", GreaterThan, 1.0),
+			term("a.c", NotMatchRegExp, "z"),
+		}},
+	}
+	for _, c := range cases {
+		and, or, err := ParseWhere(c.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error - %v", c.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(and, c.and) || !reflect.DeepEqual(or, c.or) {
+			t.Errorf("%s: unexpected terms %v, %v", c.expr, and, or)
+		}
+	}
+
+	// the terms are sent as Magda expects them
+	and, _, _ := ParseWhere(`a.b = "x:y" and a.c >= 10`)
+	if q := and[0].asUrlQuery() + "&" + and[1].asUrlQuery(); q != "a.b%3Ax%253Ay&a.c%3A%3E%3D10" {
+		t.Errorf("unexpected url query %s", q)
+	}
+	// values starting like an operator
+	for expr, expected := range map[string]string{
+		`a.b = "!nothing"`: "a.b%3A%2521nothing",
+		`a.b = ">a"`:       "a.b%3A%253Ea",
+		`a.b != "~x"`:      "a.b%3A%21%257Ex",
+		`a.b like "?%"`:    "a.b%3A%3F%253F%25",
+		`a.b = "a!"`:       "a.b%3Aa%21",
+	} {
+		and, _, err := ParseWhere(expr)
+		if err != nil {
+			t.Fatalf("%s: unexpected error - %v", expr, err)
+		}
+		if q := and[0].asUrlQuery(); q != expected {
+			t.Errorf("%s: expected url query %s, but got %s", expr, expected, q)
+		}
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{``, 1, "expected an aspect path, but got end of expression"},
+		{`a.b = pending`, 7, "strings need to be quoted"},
+		{`a.b = "pending`, 7, "unterminated string"},
+		{`a.b "x"`, 5, "expected an operator"},
+		{`a.b =< 1`, 5, "unknown operator '=<'"},
+		{`a.b not "x"`, 9, "expected 'like' after 'not'"},
+		{`a.b = 1 a.c = 2`, 9, "expected 'and' or 'or', but got 'a.c'"},
+		{`(a.b = 1 and a.c = 2`, 21, "expected ')' to close '(' at position 1"},
+		{`a.b = 1 & a.c = 2`, 9, "unexpected character '&'"},
+		{`a.b = 1 and a.c = 2 or a.d = 3`, 1, "'or' can only combine single terms"},
+		{`(a.b = 1 or a.c = 2) and (a.d = 1 or a.e = 2)`, 26, "only one group of 'or' terms"},
+	}
+	for _, c := range cases {
+		_, _, err := ParseWhere(c.expr)
+		var we *WhereError
+		if !errors.As(err, &we) {
+			t.Errorf("%s: expected WhereError, got %v", c.expr, err)
+			continue
+		}
+		if we.Pos+1 != c.pos || !strings.Contains(we.Msg, c.msg) {
+			t.Errorf("%s: unexpected error at %d - %s", c.expr, we.Pos+1, we.Msg)
+		}
+	}
+}
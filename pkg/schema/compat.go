@@ -0,0 +1,331 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/**** COMPATIBILITY ****/
+
+// SchemaChange is a difference between two versions of a JSON schema. It is
+// 'Breaking' if data valid under the old version may be rejected by the new
+// one.
+type SchemaChange struct {
+	Path     string `json:"path"` // JSON pointer into the schema
+	Breaking bool   `json:"breaking"`
+	Message  string `json:"message"`
+}
+
+// CompareSchemas lists the changes from schema 'from' to 'to'. Changes to
+// keywords it doesn't understand in detail, like 'anyOf' or '$ref', are
+// reported as breaking.
+func CompareSchemas(from map[string]interface{}, to map[string]interface{}) []SchemaChange {
+	c := &comparer{changes: []SchemaChange{}}
+	c.compare("", from, to)
+	sort.SliceStable(c.changes, func(i, j int) bool { return c.changes[i].Path < c.changes[j].Path })
+	return c.changes
+}
+
+// Breaking returns only the breaking 'changes'
+func Breaking(changes []SchemaChange) []SchemaChange {
+	res := []SchemaChange{}
+	for _, c := range changes {
+		if c.Breaking {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+type comparer struct {
+	changes []SchemaChange
+}
+
+func (c *comparer) add(path string, breaking bool, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	c.changes = append(c.changes, SchemaChange{Path: path, Breaking: breaking, Message: fmt.Sprintf(format, args...)})
+}
+
+// annotations don't affect validation
+var annotations = map[string]bool{
+	"title": true, "description": true, "default": true, "examples": true, "$comment": true,
+	"$schema": true, "$id": true, "id": true, "readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+var lowerBounds = map[string]bool{
+	"minimum": true, "exclusiveMinimum": true, "minLength": true, "minItems": true, "minProperties": true,
+}
+
+var upperBounds = map[string]bool{
+	"maximum": true, "exclusiveMaximum": true, "maxLength": true, "maxItems": true, "maxProperties": true,
+}
+
+func (c *comparer) compare(path string, from interface{}, to interface{}) {
+	if reflect.DeepEqual(from, to) {
+		return
+	}
+	f, fok := from.(map[string]interface{})
+	t, tok := to.(map[string]interface{})
+	if fok && tok {
+		c.compareObject(path, f, t)
+		return
+	}
+	// boolean schemas, where an empty schema is the same as 'true'
+	switch {
+	case from == false || to == true || tok && len(t) == 0:
+		c.add(path, false, "schema now accepts more")
+	case to == false:
+		c.add(path, true, "schema now rejects everything")
+	default:
+		c.add(path, true, "schema replaced")
+	}
+}
+
+func (c *comparer) compareObject(path string, from map[string]interface{}, to map[string]interface{}) {
+	keys := map[string]bool{}
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	for _, k := range sortedKeys(keys) {
+		fv, fok := from[k]
+		tv, tok := to[k]
+		if fok && tok && reflect.DeepEqual(fv, tv) {
+			continue
+		}
+		p := path + "/" + escapePointer(k)
+		switch {
+		case annotations[k]:
+			c.add(p, false, "'%s' changed", k)
+		case k == "type":
+			c.compareTypes(p, fv, tv)
+		case k == "required":
+			c.compareRequired(p, fv, tv)
+		case k == "properties":
+			c.compareProperties(p, from, to)
+		case k == "additionalProperties":
+			c.compareAdditional(p, fv, fok, tv, tok)
+		case k == "enum":
+			c.compareEnum(p, fv, fok, tv, tok)
+		case k == "items":
+			c.compareItems(p, fv, fok, tv, tok)
+		case k == "uniqueItems":
+			if tv == true {
+				c.add(p, true, "items now need to be unique")
+			} else {
+				c.add(p, false, "items no longer need to be unique")
+			}
+		case lowerBounds[k] || upperBounds[k]:
+			c.compareBound(p, k, fv, fok, tv, tok)
+		case !tok:
+			// all other keywords only constrain
+			c.add(p, false, "'%s' removed", k)
+		case !fok:
+			c.add(p, true, "'%s' added", k)
+		default:
+			c.add(p, true, "'%s' changed from %s to %s", k, compact(fv), compact(tv))
+		}
+	}
+}
+
+func (c *comparer) compareTypes(path string, from interface{}, to interface{}) {
+	f, t := typeSet(from), typeSet(to)
+	switch {
+	case t == nil:
+		c.add(path, false, "type no longer restricted")
+	case f == nil:
+		c.add(path, true, "type restricted to %s", compact(to))
+	default:
+		for ft := range f {
+			if !t[ft] && !(ft == "integer" && t["number"]) {
+				c.add(path, true, "type narrowed from %s to %s", compact(from), compact(to))
+				return
+			}
+		}
+		c.add(path, false, "type widened from %s to %s", compact(from), compact(to))
+	}
+}
+
+func typeSet(v interface{}) map[string]bool {
+	switch t := v.(type) {
+	case string:
+		return map[string]bool{t: true}
+	case []interface{}:
+		s := map[string]bool{}
+		for _, e := range t {
+			if n, ok := e.(string); ok {
+				s[n] = true
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+func (c *comparer) compareRequired(path string, from interface{}, to interface{}) {
+	f, t := typeSet(from), typeSet(to)
+	for _, n := range sortedKeys(t) {
+		if !f[n] {
+			c.add(path, true, "'%s' is now required", n)
+		}
+	}
+	for _, n := range sortedKeys(f) {
+		if !t[n] {
+			c.add(path, false, "'%s' is no longer required", n)
+		}
+	}
+}
+
+func (c *comparer) compareProperties(path string, from map[string]interface{}, to map[string]interface{}) {
+	fp, _ := from["properties"].(map[string]interface{})
+	tp, _ := to["properties"].(map[string]interface{})
+	names := map[string]bool{}
+	for n := range fp {
+		names[n] = true
+	}
+	for n := range tp {
+		names[n] = true
+	}
+	for _, n := range sortedKeys(names) {
+		fv, fok := fp[n]
+		tv, tok := tp[n]
+		p := path + "/" + escapePointer(n)
+		switch {
+		case fok && tok:
+			c.compare(p, fv, tv)
+		case tok:
+			// strictly, existing data may already have a value for it which
+			// doesn't match, but an optional property is meant to be additive
+			c.add(p, false, "property '%s' added", n)
+		case to["additionalProperties"] == false:
+			c.add(p, true, "property '%s' removed, and additional properties are not allowed", n)
+		default:
+			c.add(p, false, "property '%s' removed", n)
+		}
+	}
+}
+
+func (c *comparer) compareAdditional(path string, from interface{}, fok bool, to interface{}, tok bool) {
+	switch {
+	case to == false:
+		c.add(path, true, "additional properties are no longer allowed")
+	case from == false:
+		c.add(path, false, "additional properties are now allowed")
+	case !tok || to == true:
+		c.add(path, false, "additional properties are no longer restricted")
+	case !fok || from == true:
+		c.add(path, true, "additional properties are now restricted")
+	default:
+		c.compare(path, from, to)
+	}
+}
+
+func (c *comparer) compareEnum(path string, from interface{}, fok bool, to interface{}, tok bool) {
+	f, _ := from.([]interface{})
+	t, _ := to.([]interface{})
+	switch {
+	case !tok:
+		c.add(path, false, "values no longer restricted")
+	case !fok:
+		c.add(path, true, "values restricted to %s", compact(to))
+	default:
+		if removed := missing(f, t); len(removed) > 0 {
+			c.add(path, true, "values no longer allowed: %s", compact(removed))
+		}
+		if added := missing(t, f); len(added) > 0 {
+			c.add(path, false, "values now allowed: %s", compact(added))
+		}
+	}
+}
+
+// missing returns the values in 'a' which aren't in 'b'
+func missing(a []interface{}, b []interface{}) []interface{} {
+	res := []interface{}{}
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			if reflect.DeepEqual(v, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (c *comparer) compareItems(path string, from interface{}, fok bool, to interface{}, tok bool) {
+	f, fArr := from.([]interface{})
+	t, tArr := to.([]interface{})
+	switch {
+	case !tok:
+		c.add(path, false, "items no longer restricted")
+	case !fok:
+		c.add(path, true, "items now restricted")
+	case fArr && tArr:
+		for i := 0; i < len(f) || i < len(t); i++ {
+			p := fmt.Sprintf("%s/%d", path, i)
+			switch {
+			case i >= len(t):
+				c.add(p, false, "item %d no longer restricted", i)
+			case i >= len(f):
+				c.add(p, true, "item %d now restricted", i)
+			default:
+				c.compare(p, f[i], t[i])
+			}
+		}
+	case fArr || tArr:
+		c.add(path, true, "items changed from %s to %s", compact(from), compact(to))
+	default:
+		c.compare(path, from, to)
+	}
+}
+
+func (c *comparer) compareBound(path string, keyword string, from interface{}, fok bool, to interface{}, tok bool) {
+	f, fnum := from.(float64)
+	t, tnum := to.(float64)
+	lower := lowerBounds[keyword]
+	switch {
+	case !tok:
+		c.add(path, false, "'%s' removed", keyword)
+	case !fok:
+		c.add(path, true, "'%s' of %s added", keyword, compact(to))
+	case !fnum || !tnum:
+		// e.g. boolean 'exclusiveMinimum' of draft 4
+		c.add(path, true, "'%s' changed from %s to %s", keyword, compact(from), compact(to))
+	case t > f:
+		c.add(path, lower, "'%s' raised from %s to %s", keyword, compact(from), compact(to))
+	default:
+		c.add(path, !lower, "'%s' lowered from %s to %s", keyword, compact(from), compact(to))
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func compact(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
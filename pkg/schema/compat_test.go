@@ -0,0 +1,71 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		changes  []SchemaChange
+	}{
+		{"same", `{"type": "object"}`, `{"type": "object"}`, []SchemaChange{}},
+		{"annotation", `{"title": "A"}`, `{"title": "B"}`, []SchemaChange{{"/title", false, "'title' changed"}}},
+		{"new required", `{"required": ["a"]}`, `{"required": ["a", "b"]}`,
+			[]SchemaChange{{"/required", true, "'b' is now required"}}},
+		{"fewer required", `{"required": ["a", "b"]}`, `{"required": ["b"]}`,
+			[]SchemaChange{{"/required", false, "'a' is no longer required"}}},
+		{"closed", `{"properties": {"a": {}}}`, `{"properties": {"a": {}}, "additionalProperties": false}`,
+			[]SchemaChange{{"/additionalProperties", true, "additional properties are no longer allowed"}}},
+		{"opened", `{"additionalProperties": false}`, `{}`,
+			[]SchemaChange{{"/additionalProperties", false, "additional properties are now allowed"}}},
+		{"narrowed type", `{"properties": {"a": {"type": ["string", "number"]}}}`, `{"properties": {"a": {"type": "string"}}}`,
+			[]SchemaChange{{"/properties/a/type", true, `type narrowed from ["string","number"] to "string"`}}},
+		{"widened type", `{"type": "integer"}`, `{"type": ["number", "null"]}`,
+			[]SchemaChange{{"/type", false, `type widened from "integer" to ["number","null"]`}}},
+		{"property added", `{"properties": {}}`, `{"properties": {"a/b": {"type": "string"}}}`,
+			[]SchemaChange{{"/properties/a~1b", false, "property 'a/b' added"}}},
+		{"property removed", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"properties": {}, "additionalProperties": false}`,
+			[]SchemaChange{{"/properties/a", true, "property 'a' removed, and additional properties are not allowed"}}},
+		{"enum", `{"enum": ["a", "b"]}`, `{"enum": ["b", "c"]}`, []SchemaChange{
+			{"/enum", true, `values no longer allowed: ["a"]`}, {"/enum", false, `values now allowed: ["c"]`}}},
+		{"bounds", `{"minLength": 1, "maximum": 10}`, `{"minLength": 2, "maximum": 20, "maxItems": 3}`, []SchemaChange{
+			{"/maxItems", true, "'maxItems' of 3 added"}, {"/maximum", false, "'maximum' raised from 10 to 20"},
+			{"/minLength", true, "'minLength' raised from 1 to 2"}}},
+		{"items", `{"items": {"type": "string"}}`, `{"items": {"type": "string", "pattern": "^a"}}`,
+			[]SchemaChange{{"/items/pattern", true, "'pattern' added"}}},
+		{"format removed", `{"format": "date-time"}`, `{}`, []SchemaChange{{"/format", false, "'format' removed"}}},
+		{"unknown keyword", `{"anyOf": [{"type": "string"}]}`, `{"anyOf": [{"type": "number"}]}`,
+			[]SchemaChange{{"/anyOf", true, `'anyOf' changed from [{"type":"string"}] to [{"type":"number"}]`}}},
+		{"boolean schema", `{"properties": {"a": true}}`, `{"properties": {"a": false}}`,
+			[]SchemaChange{{"/properties/a", true, "schema now rejects everything"}}},
+	}
+	for _, tt := range tests {
+		var from, to map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.from), &from); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := json.Unmarshal([]byte(tt.to), &to); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		changes := CompareSchemas(from, to)
+		if len(changes) != len(tt.changes) {
+			t.Errorf("%s: expected %+v, but got %+v", tt.name, tt.changes, changes)
+			continue
+		}
+		for i := range changes {
+			if changes[i] != tt.changes[i] {
+				t.Errorf("%s: expected %+v, but got %+v", tt.name, tt.changes[i], changes[i])
+			}
+		}
+	}
+}
+
+func TestBreaking(t *testing.T) {
+	changes := []SchemaChange{{"/a", true, "x"}, {"/b", false, "y"}}
+	if b := Breaking(changes); len(b) != 1 || b[0].Path != "/a" {
+		t.Errorf("unexpected result %+v", b)
+	}
+}
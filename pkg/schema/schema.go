@@ -14,6 +14,18 @@ import (
 type ListRequest struct {
 }
 
+type AspectDefinition struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	JSONSchema map[string]interface{} `json:"jsonSchema,omitempty"`
+}
+
+func List(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) ([]AspectDefinition, error) {
+	res := []AspectDefinition{}
+	err := adapter.GetAs(ctxt, *adpt, aspectPath(nil, adpt), &res, logger)
+	return res, err
+}
+
 func ListRaw(ctxt context.Context, cmd *ListRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
 	path := aspectPath(nil, adpt)
 	return (*adpt).Get(ctxt, path, logger)
@@ -44,6 +56,12 @@ type ReadRequest struct {
 	Id string
 }
 
+func Read(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (AspectDefinition, error) {
+	res := AspectDefinition{}
+	err := adapter.GetAs(ctxt, *adpt, aspectPath(&cmd.Id, adpt), &res, logger)
+	return res, err
+}
+
 func ReadRaw(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
 	path := aspectPath(&cmd.Id, adpt)
 	return (*adpt).Get(ctxt, path, logger)
@@ -86,10 +104,7 @@ func UpdateRaw(ctxt context.Context, cmd *UpdateRequest, adpt *adapter.Adapter,
 /**** Utils ****/
 
 func aspectPath(id *string, adpt *adapter.Adapter) string {
-	path := "/api/v0/registry/aspects"
-	if (*adpt).SkipGateway() {
-		path = "/v0/aspects"
-	}
+	path := adapter.BasePath(*adpt, adapter.RegistryAPI) + "/aspects"
 	if id != nil {
 		path = path + "/" + *id
 	}
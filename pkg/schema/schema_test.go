@@ -0,0 +1,40 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	log "go.uber.org/zap"
+)
+
+func TestTypedResults(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	s := CreateRequest{Id: "cse-order", Name: "Order", Schema: map[string]interface{}{"type": "object"}}
+	if _, err := CreateRaw(ctxt, &s, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if ad, err := Read(ctxt, &ReadRequest{Id: "cse-order"}, adpt, logger); err != nil || ad.JSONSchema["type"] != "object" {
+		t.Fatalf("unexpected aspect definition %+v - %v", ad, err)
+	}
+	if ads, err := List(ctxt, &ListRequest{}, adpt, logger); err != nil || len(ads) != 1 || ads[0].Name != "Order" {
+		t.Fatalf("unexpected aspect definitions %+v - %v", ads, err)
+	}
+	if _, err := Read(ctxt, &ReadRequest{Id: "missing"}, adpt, logger); !errors.Is(err, adapter.ErrNotFound) {
+		t.Fatalf("expected not found error, but got %v", err)
+	}
+
+	// a single aspect definition isn't a list of them
+	badPath := adapter.BasePath(*adpt, adapter.RegistryAPI) + "/aspects/cse-order"
+	var res []AspectDefinition
+	err := adapter.GetAs(ctxt, *adpt, badPath, &res, logger)
+	var derr *adapter.DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected decode error, but got %v", err)
+	}
+}
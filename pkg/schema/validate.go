@@ -0,0 +1,291 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	log "go.uber.org/zap"
+)
+
+/**** VALIDATE ****/
+
+// Schema is a compiled JSON schema of an aspect definition
+type Schema struct {
+	aspect string
+	schema *jsonschema.Schema
+}
+
+// knownMetaSchemas are the '$schema' values the validator resolves without
+// fetching them
+var knownMetaSchemas = []string{
+	"json-schema.org/schema",
+	"json-schema.org/draft/2020-12/schema",
+	"json-schema.org/draft/2019-09/schema",
+	"json-schema.org/draft-07/schema",
+	"json-schema.org/draft-06/schema",
+	"json-schema.org/draft-04/schema",
+}
+
+// Compile prepares the JSON schema of 'aspect' for validation. Schemas
+// referring to other meta schemas, like the 'hyper-schema' used by many
+// Magda aspects, are treated as draft 7.
+func Compile(aspect string, jsonSchema map[string]interface{}) (*Schema, error) {
+	doc := map[string]interface{}{}
+	for k, v := range jsonSchema {
+		doc[k] = v
+	}
+	if s, ok := doc["$schema"].(string); ok && !isKnownMetaSchema(s) {
+		delete(doc, "$schema")
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft7
+	c.AssertFormat = true
+	u := "aspect://" + url.PathEscape(aspect)
+	if err := c.AddResource(u, strings.NewReader(string(body))); err != nil {
+		return nil, fmt.Errorf("invalid schema of aspect '%s' - %s", aspect, err)
+	}
+	s, err := c.Compile(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema of aspect '%s' - %s", aspect, err)
+	}
+	return &Schema{aspect: aspect, schema: s}, nil
+}
+
+func isKnownMetaSchema(s string) bool {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "http://"), "https://")
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), "#")
+	for _, k := range knownMetaSchemas {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks 'value' against the schema. Violations are returned as a
+// *ValidationError.
+func (s *Schema) Validate(value interface{}) error {
+	// the validator only knows the types produced by 'encoding/json'
+	var v interface{}
+	if b, err := json.Marshal(value); err != nil {
+		return err
+	} else if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	err := s.schema.Validate(v)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	res := &ValidationError{Aspect: s.aspect}
+	var leaves func(e *jsonschema.ValidationError)
+	leaves = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			p := e.InstanceLocation
+			if p == "" {
+				p = "/"
+			}
+			res.Errors = append(res.Errors, FieldError{Pointer: p, Message: e.Message})
+		}
+		for _, c := range e.Causes {
+			leaves(c)
+		}
+	}
+	leaves(ve)
+	sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Pointer < res.Errors[j].Pointer })
+	return res
+}
+
+// ValidationError lists all violations of the schema of an aspect
+type ValidationError struct {
+	Aspect string
+	Errors []FieldError
+}
+
+// FieldError is a single violation at the JSON pointer 'Pointer'
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		lines[i] = fmt.Sprintf("  %s: %s", f.Pointer, f.Message)
+	}
+	return fmt.Sprintf("aspect '%s' doesn't match its schema -\n%s", e.Aspect, strings.Join(lines, "\n"))
+}
+
+/**** VALIDATOR ****/
+
+// Validator checks aspect data against the schemas of the aspect definitions
+// in Magda. Schemas are fetched on first use, through 'Cache' if set.
+type Validator struct {
+	Cache *Cache
+
+	adpt    *adapter.Adapter
+	logger  *log.Logger
+	mu      sync.Mutex
+	schemas map[string]*Schema // nil if the aspect has no schema
+}
+
+func NewValidator(cache *Cache, adpt *adapter.Adapter, logger *log.Logger) *Validator {
+	return &Validator{Cache: cache, adpt: adpt, logger: logger, schemas: map[string]*Schema{}}
+}
+
+// Validate checks 'value' against the schema of 'aspect'. Aspects without a
+// definition or schema are not checked, as that is up to Magda.
+func (v *Validator) Validate(ctxt context.Context, aspect string, value interface{}) error {
+	s, err := v.schema(ctxt, aspect)
+	if err != nil || s == nil {
+		return err
+	}
+	return s.Validate(value)
+}
+
+// Forget drops the schema of 'aspect', e.g. after it has been changed, so
+// that it is read again on next use
+func (v *Validator) Forget(aspect string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.schemas, aspect)
+	if v.Cache != nil {
+		if err := v.Cache.Forget(aspect); err != nil {
+			v.logger.Warn("Removing cached aspect definition failed", log.String("aspect", aspect), log.Error(err))
+		}
+	}
+}
+
+func (v *Validator) schema(ctxt context.Context, aspect string) (*Schema, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.schemas[aspect]; ok {
+		return s, nil
+	}
+	var def AspectDefinition
+	var err error
+	if v.Cache != nil {
+		def, err = v.Cache.Read(ctxt, &ReadRequest{Id: aspect}, v.adpt, v.logger)
+	} else {
+		def, err = Read(ctxt, &ReadRequest{Id: aspect}, v.adpt, v.logger)
+	}
+	var s *Schema
+	switch {
+	case errors.Is(err, adapter.ErrNotFound):
+		v.logger.Debug("No aspect definition, skipping validation", log.String("aspect", aspect))
+	case err != nil:
+		return nil, fmt.Errorf("fetching schema of aspect '%s' - %w", aspect, err)
+	case len(def.JSONSchema) == 0:
+		v.logger.Debug("No schema in aspect definition, skipping validation", log.String("aspect", aspect))
+	default:
+		if s, err = Compile(aspect, def.JSONSchema); err != nil {
+			return nil, err
+		}
+	}
+	v.schemas[aspect] = s
+	return s, nil
+}
+
+/**** CACHE ****/
+
+// DefaultCacheTTL is how long aspect definitions are cached by default
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cache keeps aspect definitions read from Magda as files in 'Dir', and uses
+// them for 'TTL' instead of reading them again
+type Cache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// DefaultCacheDir returns 'magda-cli/schemas' in the user's cache directory,
+// which usually resolves to '~/.cache/magda-cli/schemas'
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "magda-cli", "schemas"), nil
+}
+
+// Read returns the aspect definition 'cmd.Id' from the cache if it is recent
+// enough, and otherwise reads it from Magda and updates the cache. Failing to
+// write the cache is only logged.
+func (c *Cache) Read(ctxt context.Context, cmd *ReadRequest, adpt *adapter.Adapter, logger *log.Logger) (AspectDefinition, error) {
+	res := AspectDefinition{}
+	fileName := c.cacheFile(cmd.Id)
+	if fi, err := os.Stat(fileName); err == nil && time.Since(fi.ModTime()) < c.TTL {
+		if data, err := ioutil.ReadFile(fileName); err == nil && json.Unmarshal(data, &res) == nil {
+			return res, nil
+		}
+		res = AspectDefinition{}
+	}
+
+	pld, err := ReadRaw(ctxt, cmd, adpt, logger)
+	if err != nil {
+		return res, err
+	}
+	if err := pld.AsType(&res); err != nil {
+		return res, err
+	}
+	if c.TTL > 0 {
+		if err := writeCacheFile(fileName, res); err != nil {
+			logger.Warn("Caching aspect definition failed", log.String("aspect", cmd.Id), log.Error(err))
+		}
+	}
+	return res, nil
+}
+
+// Forget removes aspect definition 'id' from the cache
+func (c *Cache) Forget(id string) error {
+	err := os.Remove(c.cacheFile(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) cacheFile(id string) string {
+	return filepath.Join(c.Dir, url.PathEscape(id)+".json")
+}
+
+func writeCacheFile(fileName string, def AspectDefinition) error {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	// write & rename, so that concurrent runs never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
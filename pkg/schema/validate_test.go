@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maxott/magda-cli/pkg/adapter"
+	"github.com/maxott/magda-cli/pkg/fakeregistry"
+	log "go.uber.org/zap"
+)
+
+func loadExampleSchema(t *testing.T) map[string]interface{} {
+	pld, err := adapter.LoadPayloadFromFile(filepath.Join("..", "..", "example", "schema", "order.json"), false)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	obj, err := pld.AsObject()
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	return obj
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Compile("cse-order", loadExampleSchema(t))
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	valid := map[string]interface{}{"id": "o1", "serviceName": "svc", "orderedAt": "2021-06-01T10:00:00Z",
+		"paramters": []interface{}{map[string]interface{}{"name": "n", "value": 5}}}
+	if err := s.Validate(valid); err != nil {
+		t.Errorf("unexpected error - %v", err)
+	}
+
+	invalid := map[string]interface{}{"id": 5, "orderedAt": "yesterday", "other": true,
+		"paramters": []interface{}{map[string]interface{}{"value": true}}}
+	err = s.Validate(invalid)
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected validation error, but got %v", err)
+	}
+	pointers := []string{}
+	for _, f := range ve.Errors {
+		pointers = append(pointers, f.Pointer)
+	}
+	// 'anyOf' reports both alternatives
+	if got := strings.Join(pointers, " "); got != "/ / /id /orderedAt /paramters/0/value /paramters/0/value" {
+		t.Errorf("unexpected pointers '%s' in %v", got, ve)
+	}
+	if ve.Aspect != "cse-order" || !strings.Contains(ve.Error(), "/orderedAt: ") {
+		t.Errorf("unexpected error %v", ve)
+	}
+}
+
+func TestCompileUnknownMetaSchema(t *testing.T) {
+	s, err := Compile("a", map[string]interface{}{
+		"$schema": "http://json-schema.org/hyper-schema#", "type": "object",
+		"properties": map[string]interface{}{"n": map[string]interface{}{"type": "number"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if err := s.Validate(map[string]interface{}{"n": "x"}); err == nil {
+		t.Errorf("expected validation error")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	var gets int32
+	reg := fakeregistry.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	a := adapter.RestAdapter(adapter.ConnectionCtxt{Host: strings.TrimPrefix(srv.URL, "http://")})
+	adpt := &a
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	if _, err := CreateRaw(ctxt, &CreateRequest{Id: "cse-order", Name: "Order", Schema: loadExampleSchema(t)}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if _, err := CreateRaw(ctxt, &CreateRequest{Id: "free", Name: "Free"}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	cache := &Cache{Dir: t.TempDir(), TTL: time.Hour}
+	v := NewValidator(cache, adpt, logger)
+	if err := v.Validate(ctxt, "cse-order", map[string]interface{}{"id": "o1"}); err == nil {
+		t.Errorf("expected validation error")
+	}
+	if err := v.Validate(ctxt, "cse-order", map[string]interface{}{"id": "o1", "serviceName": "s"}); err != nil {
+		t.Errorf("unexpected error - %v", err)
+	}
+	if err := v.Validate(ctxt, "free", map[string]interface{}{"x": 1}); err != nil {
+		t.Errorf("unexpected error - %v", err)
+	}
+	if err := v.Validate(ctxt, "missing", map[string]interface{}{"x": 1}); err != nil {
+		t.Errorf("unexpected error - %v", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 3 {
+		t.Errorf("expected 3 reads, but got %d", n)
+	}
+
+	// a new validator uses the cached definitions
+	v = NewValidator(cache, adpt, logger)
+	if err := v.Validate(ctxt, "cse-order", map[string]interface{}{"id": "o1"}); err == nil {
+		t.Errorf("expected validation error")
+	}
+	if n := atomic.LoadInt32(&gets); n != 3 {
+		t.Errorf("expected cached definition, but got %d reads", n)
+	}
+	if files, _ := ioutil.ReadDir(cache.Dir); len(files) != 2 {
+		t.Errorf("expected 2 cache files, but got %d", len(files))
+	}
+
+	// expired
+	cache.TTL = 0
+	v = NewValidator(cache, adpt, logger)
+	v.Validate(ctxt, "cse-order", map[string]interface{}{})
+	if n := atomic.LoadInt32(&gets); n != 4 {
+		t.Errorf("expected expired definition to be read, but got %d reads", n)
+	}
+
+	// failures to read a schema keep their cause
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer denied.Close()
+	d := adapter.RestAdapter(adapter.ConnectionCtxt{Host: strings.TrimPrefix(denied.URL, "http://")})
+	v = NewValidator(nil, &d, logger)
+	if err := v.Validate(ctxt, "cse-order", map[string]interface{}{}); !errors.Is(err, adapter.ErrForbidden) {
+		t.Errorf("expected forbidden error, but got %v", err)
+	}
+}
+
+func TestCacheForget(t *testing.T) {
+	adpt := fakeregistry.New().TestAdapter(t, adapter.ConnectionCtxt{})
+	ctxt := context.Background()
+	logger := log.NewNop()
+
+	number := map[string]interface{}{"type": "object", "properties": map[string]interface{}{"n": map[string]interface{}{"type": "number"}}}
+	if _, err := CreateRaw(ctxt, &CreateRequest{Id: "a", Name: "A", Schema: number}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	v := NewValidator(&Cache{Dir: t.TempDir(), TTL: time.Hour}, adpt, logger)
+	value := map[string]interface{}{"n": "x"}
+	if err := v.Validate(ctxt, "a", value); err == nil {
+		t.Errorf("expected validation error")
+	}
+
+	number["properties"] = map[string]interface{}{"n": map[string]interface{}{"type": "string"}}
+	if _, err := UpdateRaw(ctxt, &UpdateRequest{Id: "a", Name: "A", Schema: number}, adpt, logger); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+	if err := v.Validate(ctxt, "a", value); err == nil {
+		t.Errorf("expected the cached schema to be used")
+	}
+	v.Forget("a")
+	if err := v.Validate(ctxt, "a", value); err != nil {
+		t.Errorf("unexpected error - %v", err)
+	}
+}
@@ -78,7 +78,7 @@ func DatasetRaw(ctxt context.Context, cmd *DatasetRequest, adpt *adapter.Adapter
 /**** UTILS ****/
 
 func searchPath(id *string, adpt *adapter.Adapter) string {
-	path := "/api/v0/search/datasets"
+	path := adapter.BasePath(*adpt, adapter.SearchAPI) + "/datasets"
 	if id != nil {
 		path = path + "/" + *id
 	}